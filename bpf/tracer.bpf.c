@@ -2,6 +2,7 @@
 #include <bpf/bpf_helpers.h>
 #include <bpf/bpf_tracing.h>
 #include <bpf/bpf_core_read.h>
+#include <bpf/bpf_endian.h>
 #include "tracer.h"
 
 char LICENSE[] SEC("license") = "Dual BSD/GPL";
@@ -12,15 +13,178 @@ struct {
     __uint(max_entries, 1 << 24);
 } events SEC(".maps");
 
+// Размер задаётся из Go (--max-pids)
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
-    __uint(max_entries, 1024);
+    __uint(max_entries, 8192);
     __type(key, u32);
     __type(value, u32);
 } pid_filters SEC(".maps");
 
+// Потомки, не попавшие в pid_filters под --follow из-за переполнения
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, u64);
+} follow_lost SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, CONFIG_MAX);
+    __type(key, u32);
+    __type(value, u64);
+} config SEC(".maps");
+
+// --cgroup: fd-ы cgroup-ов. Проверяется вложенность, а не id, поэтому
+// cgroup-ы, созданные после запуска (новые контейнеры, pod-ы), тоже проходят
+struct {
+    __uint(type, BPF_MAP_TYPE_CGROUP_ARRAY);
+    __uint(max_entries, MAX_CGROUP_FILTERS);
+    __type(key, u32);
+    __type(value, u32);
+} cgroup_filters SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u32);
+    __type(value, u8);
+} uid_filters SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u32);
+    __type(value, u8);
+} tid_filters SEC(".maps");
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 1024);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct comm_key);
+    __type(value, u8);
+} comm_filters SEC(".maps");
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 1024);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct path_key);
+    __type(value, u8);
+} path_filters SEC(".maps");
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 1024);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct addr_key);
+    __type(value, u8);
+} saddr_filters SEC(".maps");
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, 1024);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct addr_key);
+    __type(value, u8);
+} daddr_filters SEC(".maps");
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u16);
+    __type(value, u8);
+} port_filters SEC(".maps");
+// Номера syscall-ов для SYSCALL-событий, значение — FILTER_ALLOW/FILTER_DENY
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u32);
+    __type(value, u8);
+} syscall_filters SEC(".maps");
+// Ключ path_key слишком велик для стека
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, struct path_key);
+} path_scratch SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u32);
+    __type(value, u8);
+} capture_pids SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 64);
+    __type(key, u32);
+    __type(value, u8);
+} capture_fds SEC(".maps");
+
+// payload_event не помещается на стек — собираем его здесь
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, struct payload_event);
+} payload_scratch SEC(".maps");
+
+// Аргументы syscall-а между sys_enter и sys_exit, ключ — pid_tgid потока
+struct syscall_args {
+    u64 args[6];
+    long nr;    // номер syscall-а, чей вход сохранил аргументы
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct syscall_args);
+} inflight SEC(".maps");
+
+// Аргументы файловых syscall-ов, приведённые к одному виду на входе
+struct fs_args {
+    u32 type;
+    u32 mode;
+    u32 uid;
+    u32 gid;
+    int flags;
+    int dfd;
+    int dfd2;
+    int fd;
+    s64 size;
+    u64 path;
+    u64 path2;
+    long nr;    // номер syscall-а, как у syscall_args
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct fs_args);
+} fs_inflight SEC(".maps");
+
+// Состояние udp_sendmsg между входом и выходом
+struct udp_send_args {
+    u64 sk;
+    u64 buf;        // начало данных в user space, для DNS
+    u8 daddr[16];   // адрес из msg_name (sendto); иначе берём у подключённого сокета
+    u16 dport;
+    u16 has_dest;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct udp_send_args);
+} udp_inflight SEC(".maps");
+
 // Dynamic UPROBE: карта конфигурации
-// Ключ: u64 key = (u64)pid << 32 | func_addr
+// Ключ: cookie привязки (UprobeOptions.Cookie) — IP функции в PIE и
+// библиотеках не совпадает с адресом из ELF. Без cookie (ядро до 5.15) —
+// (u64)pid << 32 | адрес функции, pid 0 — привязка ко всем процессам
 // Значение: char[64] (имя функции) или произвольные флаги
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
@@ -29,46 +193,257 @@ struct {
     __type(value, char[64]);
 } uprobe_configs SEC(".maps");
 
+// Стеки событий (--stacks). Без BPF_F_REUSE_STACKID: с ним коллизия затирает
+// стек, на который ещё ссылается событие в кольцевом буфере, и Go прочитал бы
+// чужой стек. Коллизия даёт -EEXIST, Go считает такой стек потерянным
+struct {
+    __uint(type, BPF_MAP_TYPE_STACK_TRACE);
+    __uint(max_entries, 16384);
+    __type(key, u32);
+    __uint(value_size, MAX_STACK_DEPTH * sizeof(u64));
+} stack_traces SEC(".maps");
+
 // =========== HELPERS ===========
+static __always_inline u64 get_config(u32 key) {
+    u64 *val = bpf_map_lookup_elem(&config, &key);
+    return val ? *val : 0;
+}
+// Решение по результату поиска в comm_filters/path_filters
+static __always_inline int rule_pass(u64 mode, u8 *action) {
+    if (action)
+        return *action == FILTER_ALLOW;
+    return mode != FILTER_ALLOWLIST;
+}
+
+static __always_inline int comm_pass(void) {
+    u64 mode = get_config(CONFIG_COMM_FILTER);
+    if (mode == FILTER_OFF)
+        return 1;
+    // Как и в событии, имя процесса — comm лидера группы
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct comm_key key = {};
+    key.prefixlen = sizeof(key.comm) * 8;
+    BPF_CORE_READ_STR_INTO(&key.comm, task, group_leader, comm);
+    return rule_pass(mode, bpf_map_lookup_elem(&comm_filters, &key));
+}
+
+// Читает путь в per-CPU буфер и проверяет его по path_filters.
+// Возвращает буфер с путём или NULL, если событие отфильтровано.
+static __always_inline const char *path_checked(const void *src, int user) {
+    u32 zero = 0;
+    struct path_key *key = bpf_map_lookup_elem(&path_scratch, &zero);
+    if (!key)
+        return NULL;
+    // Хвост прошлого пути после NUL иначе совпал бы с более длинным правилом
+    u64 mode = get_config(CONFIG_PATH_FILTER);
+    if (mode != FILTER_OFF)
+        __builtin_memset(key->path, 0, sizeof(key->path));
+    if (user)
+        bpf_probe_read_user_str(key->path, sizeof(key->path), src);
+    else
+        bpf_probe_read_kernel_str(key->path, sizeof(key->path), src);
+    // Относительный путь (от cwd или dirfd) правилам не сравнить — его
+    // проверяет Go, разрешив каталог
+    if (mode == FILTER_OFF || key->path[0] != '/')
+        return key->path;
+    key->prefixlen = sizeof(key->path) * 8;
+    if (!rule_pass(mode, bpf_map_lookup_elem(&path_filters, key)))
+        return NULL;
+    return key->path;
+}
+
+static __always_inline int addr_pass(void *filters, u32 config, u8 *addr) {
+    u64 mode = get_config(config);
+    if (mode == FILTER_OFF)
+        return 1;
+    struct addr_key key = { .prefixlen = 128 };
+    __builtin_memcpy(key.addr, addr, sizeof(key.addr));
+    return rule_pass(mode, bpf_map_lookup_elem(filters, &key));
+}
+
+// Сетевые фильтры TCP_CONN/UDP/DNS: направление, адреса источника/назначения, порт назначения
+static __always_inline int conn_pass(struct event *e) {
+    u64 dir = get_config(CONFIG_TCP_DIRECTION);
+    if (dir && dir != e->conn.direction)
+        return 0;
+    u64 mode = get_config(CONFIG_PORT_FILTER);
+    if (mode != FILTER_OFF) {
+        u16 port = e->conn.dport;
+        if (!rule_pass(mode, bpf_map_lookup_elem(&port_filters, &port)))
+            return 0;
+    }
+    return addr_pass(&saddr_filters, CONFIG_SADDR_FILTER, e->conn.saddr) &&
+           addr_pass(&daddr_filters, CONFIG_DADDR_FILTER, e->conn.daddr);
+}
+
+// Текущая задача в одном из cgroup-ов --cgroup или ниже
+static __always_inline int cgroup_pass(void) {
+    u64 n = get_config(CONFIG_CGROUP_FILTER);
+    if (n == 0)
+        return 1;
+    for (u32 i = 0; i < MAX_CGROUP_FILTERS; i++) {
+        if (i >= n)
+            break;
+        if (bpf_current_task_under_cgroup(&cgroup_filters, i) == 1)
+            return 1;
+    }
+    return 0;
+}
+
 static __always_inline int filter_pass(u32 pid, u32 event_type) {
     u32 *filter = bpf_map_lookup_elem(&pid_filters, &pid);
-    if (filter && !(*filter & (1 << (event_type - 1))))
+    if (filter) {
+        if (!(*filter & (1U << (event_type - 1))))
+            return 0;
+    } else {
+        if (get_config(CONFIG_PID_FILTER))
+            return 0;
+        // Без --pid маска --events общая для всех процессов
+        u64 mask = get_config(CONFIG_EVENT_MASK);
+        if (mask && !(mask & (1ULL << (event_type - 1))))
+            return 0;
+    }
+    if (!cgroup_pass())
         return 0;
-    return 1;
+    if (get_config(CONFIG_UID_FILTER)) {
+        u32 uid = bpf_get_current_uid_gid();
+        if (!bpf_map_lookup_elem(&uid_filters, &uid))
+            return 0;
+    }
+    if (get_config(CONFIG_TID_FILTER)) {
+        u32 tid = bpf_get_current_pid_tgid();
+        if (!bpf_map_lookup_elem(&tid_filters, &tid))
+            return 0;
+    }
+    return comm_pass();
+}
+// Событие, где действует один процесс, а затронут другой (сигнал, OOM kill):
+// интересно, если проходит действующий или цель есть в pid_filters —
+// при --pid важно увидеть, кто прислал сигнал нашему процессу
+static __always_inline int target_pass(u32 actor, u32 target, u32 event_type) {
+    if (filter_pass(actor, event_type))
+        return 1;
+    u32 *mask = bpf_map_lookup_elem(&pid_filters, &target);
+    return mask && (*mask & (1U << (event_type - 1)));
+}
+// PID процесса в его собственном pid namespace (для контейнеров отличается от глобального)
+static __always_inline u32 task_ns_pid(struct task_struct *task) {
+    struct pid *pid = BPF_CORE_READ(task, group_leader, thread_pid);
+    unsigned int level = BPF_CORE_READ(pid, level);
+    struct upid upid = {};
+    bpf_core_read(&upid, sizeof(upid), &pid->numbers[level]);
+    return upid.nr;
 }
 static __always_inline void fill_common(struct event *e, u32 type, u32 pid) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
     e->type = type;
     e->pid = pid;
-    e->tgid = bpf_get_current_pid_tgid();
+    e->tid = bpf_get_current_pid_tgid();
+    e->ns_pid = task_ns_pid(task);
     e->timestamp = bpf_ktime_get_ns();
-    bpf_get_current_comm(&e->comm, sizeof(e->comm));
+    e->cgroup_id = bpf_get_current_cgroup_id();
+    u64 uid_gid = bpf_get_current_uid_gid();
+    e->uid = uid_gid;
+    e->gid = uid_gid >> 32;
+    // comm у каждого потока свой, имя процесса берём у лидера группы
+    BPF_CORE_READ_STR_INTO(&e->comm, task, group_leader, comm);
+    bpf_get_current_comm(&e->thread_comm, sizeof(e->thread_comm));
+    e->user_stack_id = -1;
+    e->kernel_stack_id = -1;
 }
 
-// =========== SYSTEM CALLS ===========
+// Стеки пользователя и ядра, если тип события есть в CONFIG_STACKS.
+// Пользовательский стек разворачивается по frame pointer-ам
+static __always_inline void fill_stacks(void *ctx, struct event *e) {
+    if (!(get_config(CONFIG_STACKS) & (1ULL << (e->type - 1))))
+        return;
+    e->user_stack_id = bpf_get_stackid(ctx, &stack_traces, BPF_F_USER_STACK);
+    e->kernel_stack_id = bpf_get_stackid(ctx, &stack_traces, 0);
+}
 
-// EXECVE
-SEC("tracepoint/syscalls/sys_enter_execve")
-int handle_execve(struct trace_event_raw_sys_enter *ctx) {
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
-    if (!filter_pass(pid, EVENT_TYPE_EXECVE))
+static __always_inline void stash_args(struct trace_event_raw_sys_enter *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct syscall_args a = {};
+    #pragma unroll
+    for (int i = 0; i < 6; i++)
+        a.args[i] = ctx->args[i];
+    a.nr = ctx->id;
+    bpf_map_update_elem(&inflight, &id, &a, BPF_ANY);
+}
+// Забирает аргументы, сохранённые на входе того же syscall-а; 0 — если вход
+// был отфильтрован. Запись от другого syscall-а (его выхода мы не видели,
+// например, у успешного execve) выбрасывается, а не отдаётся чужому выходу
+static __always_inline int pop_args(struct trace_event_raw_sys_exit *ctx, struct syscall_args *out) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct syscall_args *a = bpf_map_lookup_elem(&inflight, &id);
+    if (!a)
         return 0;
-    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
-    fill_common(e, EVENT_TYPE_EXECVE, pid);
-    bpf_probe_read_user_str(e->execve.filename, sizeof(e->execve.filename), (void *)ctx->args[0]);
-    bpf_ringbuf_submit(e, 0);
-    return 0;
+    int same = a->nr == ctx->id;
+    if (same)
+        *out = *a;
+    bpf_map_delete_elem(&inflight, &id);
+    return same;
+}
+
+// Нужно ли захватывать содержимое read/write для этого процесса и fd
+static __always_inline int capture_selected(u32 pid, u32 fd) {
+    if (!get_config(CONFIG_CAPTURE_BYTES))
+        return 0;
+    if (get_config(CONFIG_CAPTURE_PIDS) && !bpf_map_lookup_elem(&capture_pids, &pid))
+        return 0;
+    if (get_config(CONFIG_CAPTURE_FDS) && !bpf_map_lookup_elem(&capture_fds, &fd))
+        return 0;
+    return filter_pass(pid, EVENT_TYPE_DATA);
+}
+static __always_inline void submit_data(u32 pid, struct syscall_args *a, u32 dir, long ret) {
+    if (ret <= 0)
+        return;
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe)
+        return;
+    u64 n = get_config(CONFIG_CAPTURE_BYTES);
+    if (n > (u64)ret)
+        n = ret;
+    n &= MAX_PAYLOAD - 1;   // для верификатора; userspace ограничивает лимит MAX_PAYLOAD-1
+    if (bpf_probe_read_user(pe->payload, n, (void *)a->args[1]))
+        return;
+    fill_common(&pe->ev, EVENT_TYPE_DATA, pid);
+    pe->ev.data.fd = (int)a->args[0];
+    pe->ev.data.dir = dir;
+    pe->ev.data.ret = ret;
+    pe->ev.data.len = n;
+    bpf_ringbuf_output(&events, pe, sizeof(pe->ev) + n, 0);
 }
 
-// OPENAT
+// =========== SYSTEM CALLS ===========
+
+// OPENAT (событие уходит на выходе, чтобы знать полученный fd)
 SEC("tracepoint/syscalls/sys_enter_openat")
 int handle_openat(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
     if (!filter_pass(pid, EVENT_TYPE_OPEN))
         return 0;
+    stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_openat")
+int handle_openat_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    const char *path = path_checked((void *)a.args[1], 1);
+    if (!path)
+        return 0;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
     fill_common(e, EVENT_TYPE_OPEN, pid);
-    bpf_probe_read_user_str(e->open.filename, sizeof(e->open.filename), (void *)ctx->args[1]);
-    e->open.flags = (int)ctx->args[2];
+    fill_stacks(ctx, e);
+    bpf_probe_read_kernel_str(e->open.filename, sizeof(e->open.filename), path);
+    e->open.flags = (int)a.args[2];
+    e->open.fd = (int)ctx->ret;
+    e->open.dfd = (int)a.args[0];
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
@@ -77,11 +452,15 @@ int handle_openat(struct trace_event_raw_sys_enter *ctx) {
 SEC("tracepoint/syscalls/sys_enter_read")
 int handle_read(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (capture_selected(pid, (u32)ctx->args[0]))
+        stash_args(ctx);
     if (!filter_pass(pid, EVENT_TYPE_READ))
         return 0;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
     fill_common(e, EVENT_TYPE_READ, pid);
+    fill_stacks(ctx, e);
     e->io.fd = (int)ctx->args[0];
+    e->io.ret = 0;
     e->io.count = (u64)ctx->args[2];
     bpf_ringbuf_submit(e, 0);
     return 0;
@@ -91,130 +470,1972 @@ int handle_read(struct trace_event_raw_sys_enter *ctx) {
 SEC("tracepoint/syscalls/sys_enter_write")
 int handle_write(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (capture_selected(pid, (u32)ctx->args[0]))
+        stash_args(ctx);
     if (!filter_pass(pid, EVENT_TYPE_WRITE))
         return 0;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
     fill_common(e, EVENT_TYPE_WRITE, pid);
+    fill_stacks(ctx, e);
     e->io.fd = (int)ctx->args[0];
+    e->io.ret = 0;
     e->io.count = (u64)ctx->args[2];
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
 
-// ACCEPT4
+// Содержимое read/write берём на выходе — только реально переданные байты
+SEC("tracepoint/syscalls/sys_exit_read")
+int handle_read_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    submit_data(bpf_get_current_pid_tgid() >> 32, &a, 0, ctx->ret);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_write")
+int handle_write_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    submit_data(bpf_get_current_pid_tgid() >> 32, &a, 1, ctx->ret);
+    return 0;
+}
+
+// ACCEPT4 (на выходе: ret — fd нового соединения)
 SEC("tracepoint/syscalls/sys_enter_accept4")
 int handle_accept(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
     if (!filter_pass(pid, EVENT_TYPE_ACCEPT))
         return 0;
+    stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_accept4")
+int handle_accept_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
     fill_common(e, EVENT_TYPE_ACCEPT, pid);
-    e->io.fd = (int)ctx->args[0];
+    fill_stacks(ctx, e);
+    e->io.fd = (int)a.args[0];
+    e->io.ret = (int)ctx->ret;
     e->io.count = 0;
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
 
-// CONNECT
+// CONNECT (на выходе, чтобы сокет уже был связан с удалённым адресом)
 SEC("tracepoint/syscalls/sys_enter_connect")
 int handle_connect(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
     if (!filter_pass(pid, EVENT_TYPE_CONNECT))
         return 0;
+    stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_connect")
+int handle_connect_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
     fill_common(e, EVENT_TYPE_CONNECT, pid);
-    e->io.fd = (int)ctx->args[0];
+    fill_stacks(ctx, e);
+    e->io.fd = (int)a.args[0];
+    e->io.ret = (int)ctx->ret;
     e->io.count = 0;
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
 
-// CLONE
-SEC("tracepoint/syscalls/sys_enter_clone")
-int handle_clone(struct trace_event_raw_sys_enter *ctx) {
+// CLOSE
+SEC("tracepoint/syscalls/sys_enter_close")
+int handle_close(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
-    if (!filter_pass(pid, EVENT_TYPE_CLONE))
+    if (!filter_pass(pid, EVENT_TYPE_CLOSE))
         return 0;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
-    fill_common(e, EVENT_TYPE_CLONE, pid);
+    fill_common(e, EVENT_TYPE_CLOSE, pid);
+    fill_stacks(ctx, e);
+    e->io.fd = (int)ctx->args[0];
+    e->io.ret = 0;
+    e->io.count = 0;
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
 
-// EXIT GROUP
-SEC("tracepoint/syscalls/sys_enter_exit_group")
-int handle_exit(struct trace_event_raw_sys_enter *ctx) {
+// dup2/dup3 молча закрывают newfd — для кэша fd в userspace это тот же CLOSE
+SEC("tracepoint/syscalls/sys_enter_dup3")
+int handle_dup(struct trace_event_raw_sys_enter *ctx) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
-    if (!filter_pass(pid, EVENT_TYPE_EXIT))
+    if (!filter_pass(pid, EVENT_TYPE_CLOSE))
         return 0;
+    stash_args(ctx);
+    return 0;
+}
+SEC("tracepoint/syscalls/sys_exit_dup3")
+int handle_dup_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    // dup2(fd, fd) ничего не закрывает
+    if (ctx->ret < 0 || a.args[0] == a.args[1])
+        return 0;
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
-    fill_common(e, EVENT_TYPE_EXIT, pid);
+    fill_common(e, EVENT_TYPE_CLOSE, pid);
+    fill_stacks(ctx, e);
+    e->io.fd = (int)a.args[1];
+    e->io.ret = (int)a.args[0];
+    e->io.count = 1;
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
 
-// TCP connect (kprobe)
-SEC("kprobe/tcp_connect")
-int handle_tcp_connect(struct pt_regs *ctx) {
+// =========== FILE SYSTEM MUTATIONS ===========
+
+#define AT_FDCWD     -100
+#define AT_REMOVEDIR 0x200
+
+static __always_inline int stash_fs(struct trace_event_raw_sys_enter *ctx, struct fs_args *a) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (!filter_pass(pid, a->type))
+        return 0;
+    a->nr = ctx->id;
+    u64 id = bpf_get_current_pid_tgid();
+    bpf_map_update_elem(&fs_inflight, &id, a, BPF_ANY);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_unlinkat")
+int handle_unlinkat(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = (ctx->args[2] & AT_REMOVEDIR) ? EVENT_TYPE_RMDIR : EVENT_TYPE_UNLINK;
+    a.dfd = (int)ctx->args[0];
+    a.path = ctx->args[1];
+    a.flags = (int)ctx->args[2];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_unlink")
+int handle_unlink(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_UNLINK;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_rmdir")
+int handle_rmdir(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_RMDIR;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    return stash_fs(ctx, &a);
+}
+
+// renameat2 и renameat: (olddfd, oldname, newdfd, newname[, flags])
+SEC("tracepoint/syscalls/sys_enter_renameat2")
+int handle_renameat2(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_RENAME;
+    a.dfd = (int)ctx->args[0];
+    a.path = ctx->args[1];
+    a.dfd2 = (int)ctx->args[2];
+    a.path2 = ctx->args[3];
+    a.flags = (int)ctx->args[4];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_renameat")
+int handle_renameat(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_RENAME;
+    a.dfd = (int)ctx->args[0];
+    a.path = ctx->args[1];
+    a.dfd2 = (int)ctx->args[2];
+    a.path2 = ctx->args[3];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_rename")
+int handle_rename(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_RENAME;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    a.dfd2 = AT_FDCWD;
+    a.path2 = ctx->args[1];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_fchmodat")
+int handle_fchmodat(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_CHMOD;
+    a.dfd = (int)ctx->args[0];
+    a.path = ctx->args[1];
+    a.mode = (u32)ctx->args[2];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_chmod")
+int handle_chmod(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_CHMOD;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    a.mode = (u32)ctx->args[1];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_fchownat")
+int handle_fchownat(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_CHOWN;
+    a.dfd = (int)ctx->args[0];
+    a.path = ctx->args[1];
+    a.uid = (u32)ctx->args[2];
+    a.gid = (u32)ctx->args[3];
+    a.flags = (int)ctx->args[4];
+    return stash_fs(ctx, &a);
+}
+
+// chown и lchown: (filename, user, group)
+SEC("tracepoint/syscalls/sys_enter_chown")
+int handle_chown(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_CHOWN;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    a.uid = (u32)ctx->args[1];
+    a.gid = (u32)ctx->args[2];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_mkdirat")
+int handle_mkdirat(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_MKDIR;
+    a.dfd = (int)ctx->args[0];
+    a.path = ctx->args[1];
+    a.mode = (u32)ctx->args[2];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_mkdir")
+int handle_mkdir(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_MKDIR;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    a.mode = (u32)ctx->args[1];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_truncate")
+int handle_truncate(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_TRUNCATE;
+    a.dfd = AT_FDCWD;
+    a.path = ctx->args[0];
+    a.size = (s64)ctx->args[1];
+    return stash_fs(ctx, &a);
+}
+
+SEC("tracepoint/syscalls/sys_enter_ftruncate")
+int handle_ftruncate(struct trace_event_raw_sys_enter *ctx) {
+    struct fs_args a = {};
+    a.type = EVENT_TYPE_TRUNCATE;
+    a.fd = (int)ctx->args[0];
+    a.size = (s64)ctx->args[1];
+    return stash_fs(ctx, &a);
+}
+
+// Общий выход для всех syscall-ов выше: loader привязывает его к их sys_exit_*
+// tracepoint-ам, имя в секции условное
+SEC("tracepoint/syscalls/sys_exit_fs")
+int handle_fs_exit(struct trace_event_raw_sys_exit *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct fs_args *a = bpf_map_lookup_elem(&fs_inflight, &id);
+    if (!a)
+        return 0;
+    // Вход другого syscall-а: его выхода мы не видели
+    if (a->nr != ctx->id) {
+        bpf_map_delete_elem(&fs_inflight, &id);
+        return 0;
+    }
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe) {
+        bpf_map_delete_elem(&fs_inflight, &id);
+        return 0;
+    }
+    fill_common(&pe->ev, a->type, id >> 32);
+    fill_stacks(ctx, &pe->ev);
+    pe->ev.fs.path[0] = 0;
+    if (a->path)
+        bpf_probe_read_user_str(pe->ev.fs.path, sizeof(pe->ev.fs.path), (void *)a->path);
+    pe->ev.fs.size = a->size;
+    pe->ev.fs.ret = (int)ctx->ret;
+    pe->ev.fs.flags = a->flags;
+    pe->ev.fs.mode = a->mode;
+    pe->ev.fs.uid = a->uid;
+    pe->ev.fs.gid = a->gid;
+    pe->ev.fs.dfd = a->dfd;
+    pe->ev.fs.dfd2 = a->dfd2;
+    pe->ev.fs.fd = a->fd;
+    long n = 0;
+    if (a->path2) {
+        n = bpf_probe_read_user_str(pe->payload, sizeof(pe->ev.fs.path), (void *)a->path2);
+        if (n < 0)
+            n = 0;
+    }
+    bpf_map_delete_elem(&fs_inflight, &id);
+    n &= MAX_PAYLOAD - 1;
+    bpf_ringbuf_output(&events, pe, sizeof(pe->ev) + n, 0);
+    return 0;
+}
+
+#define AF_INET  2
+#define AF_INET6 10
+
+// Адрес в виде IPv4-mapped IPv6 (см. struct addr_key)
+static __always_inline void store_addr4(u8 *dst, u32 addr) {
+    __builtin_memset(dst, 0, 10);
+    dst[10] = 0xff;
+    dst[11] = 0xff;
+    __builtin_memcpy(dst + 12, &addr, 4);
+}
+
+// Заполняет e->tcp из сокета. local/remote раскладываются в src/dst по направлению.
+static __always_inline void fill_tcp(struct event *e, struct sock *sk, u16 direction) {
+    u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+    u8 local[16], remote[16];
+    if (family == AF_INET6) {
+        BPF_CORE_READ_INTO(&local, sk, __sk_common.skc_v6_rcv_saddr.in6_u.u6_addr8);
+        BPF_CORE_READ_INTO(&remote, sk, __sk_common.skc_v6_daddr.in6_u.u6_addr8);
+    } else {
+        store_addr4(local, BPF_CORE_READ(sk, __sk_common.skc_rcv_saddr));
+        store_addr4(remote, BPF_CORE_READ(sk, __sk_common.skc_daddr));
+    }
+    u16 lport = BPF_CORE_READ(sk, __sk_common.skc_num);
+    u16 rport = bpf_ntohs(BPF_CORE_READ(sk, __sk_common.skc_dport));
+    e->conn.family = family;
+    e->conn.direction = direction;
+    if (direction == TCP_DIR_IN) {
+        __builtin_memcpy(e->conn.saddr, remote, 16);
+        __builtin_memcpy(e->conn.daddr, local, 16);
+        e->conn.sport = rport;
+        e->conn.dport = lport;
+    } else {
+        __builtin_memcpy(e->conn.saddr, local, 16);
+        __builtin_memcpy(e->conn.daddr, remote, 16);
+        e->conn.sport = lport;
+        e->conn.dport = rport;
+    }
+}
+
+static __always_inline int submit_tcp(struct sock *sk, u16 direction) {
     u32 pid = bpf_get_current_pid_tgid() >> 32;
-    if (!filter_pass(pid, EVENT_TYPE_TCP_CONN))
+    if (!sk || !filter_pass(pid, EVENT_TYPE_TCP_CONN))
         return 0;
-    struct sock *sk = (struct sock *)PT_REGS_PARM1(ctx);
     struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_tcp(e, sk, direction);
+    if (!conn_pass(e)) {
+        bpf_ringbuf_discard(e, 0);
+        return 0;
+    }
     fill_common(e, EVENT_TYPE_TCP_CONN, pid);
-    bpf_probe_read_kernel(&e->tcp.saddr, sizeof(u32), &sk->__sk_common.skc_rcv_saddr);
-    bpf_probe_read_kernel(&e->tcp.daddr, sizeof(u32), &sk->__sk_common.skc_daddr);
-    bpf_probe_read_kernel(&e->tcp.sport, sizeof(u16), &sk->__sk_common.skc_num);
-    bpf_probe_read_kernel(&e->tcp.dport, sizeof(u16), &sk->__sk_common.skc_dport);
     bpf_ringbuf_submit(e, 0);
     return 0;
 }
 
-// =====================
-// UNIVERSAL UPROBE HANDLER (из uprobes.bpf.c)
-// =====================
+// TCP connect (kprobe): исходящее соединение, порт источника уже выбран
+SEC("kprobe/tcp_connect")
+int handle_tcp_connect(struct pt_regs *ctx) {
+    return submit_tcp((struct sock *)PT_REGS_PARM1(ctx), TCP_DIR_OUT);
+}
 
-SEC("uprobe")
-int handle_generic_uprobe(struct pt_regs *ctx) {
-    u32 pid = bpf_get_current_pid_tgid() >> 32;
-    u64 func_addr = PT_REGS_IP(ctx);  // IP = addr функции
+// Входящее соединение, принятое accept()
+SEC("kretprobe/inet_csk_accept")
+int handle_tcp_accept(struct pt_regs *ctx) {
+    return submit_tcp((struct sock *)PT_REGS_RC(ctx), TCP_DIR_IN);
+}
 
-    // Составляем ключ (pid << 32 | addr)
-    u64 key = ((u64)pid << 32) | func_addr;
-    char *func_name = bpf_map_lookup_elem(&uprobe_configs, &key);
-    if (!func_name) {
-        // Если имя не нашли — не шлем эвент
+// =========== UDP / DNS ===========
+
+#define DNS_PORT 53
+
+// iov_iter менялся: iter_type с 5.14, ITER_UBUF с 6.0, iov переименован в __iov в 6.4,
+// значения enum iter_type тоже переставлялись — всё берём через CO-RE
+struct iov_iter___new {
+    u8 iter_type;
+    const struct iovec *__iov;
+    void *ubuf;
+} __attribute__((preserve_access_index));
+struct iov_iter___old {
+    u8 iter_type;
+    const struct iovec *iov;
+} __attribute__((preserve_access_index));
+enum iter_type___compat {
+    ITER_IOVEC___compat,
+    ITER_UBUF___compat,
+};
+
+// Адрес первого user-буфера сообщения; 0, если определить не удалось
+static __always_inline u64 msg_user_buf(struct msghdr *msg) {
+    void *it = &msg->msg_iter;
+    if (!bpf_core_field_exists(((struct iov_iter___old *)it)->iter_type))
         return 0;
+    u8 type = BPF_CORE_READ((struct iov_iter___old *)it, iter_type);
+    if (bpf_core_enum_value_exists(enum iter_type___compat, ITER_UBUF___compat) &&
+        type == bpf_core_enum_value(enum iter_type___compat, ITER_UBUF___compat))
+        return (u64)BPF_CORE_READ((struct iov_iter___new *)it, ubuf);
+    if (type != bpf_core_enum_value(enum iter_type___compat, ITER_IOVEC___compat))
+        return 0;
+    const struct iovec *iov;
+    if (bpf_core_field_exists(((struct iov_iter___new *)it)->__iov))
+        iov = BPF_CORE_READ((struct iov_iter___new *)it, __iov);
+    else
+        iov = BPF_CORE_READ((struct iov_iter___old *)it, iov);
+    return (u64)BPF_CORE_READ(iov, iov_base);
+}
+
+static __always_inline int udp_wanted(u32 pid) {
+    return filter_pass(pid, EVENT_TYPE_UDP) || filter_pass(pid, EVENT_TYPE_DNS);
+}
+
+// Отправляет UDP и, если это порт 53, DNS-событие с началом сообщения в payload
+static __always_inline void submit_udp(struct payload_event *pe, u32 pid, const void *buf, int user, u32 len) {
+    if (!conn_pass(&pe->ev))
+        return;
+    if (filter_pass(pid, EVENT_TYPE_UDP)) {
+        fill_common(&pe->ev, EVENT_TYPE_UDP, pid);
+        bpf_ringbuf_output(&events, &pe->ev, sizeof(pe->ev), 0);
     }
+    if (pe->ev.conn.sport != DNS_PORT && pe->ev.conn.dport != DNS_PORT)
+        return;
+    if (!buf || !filter_pass(pid, EVENT_TYPE_DNS))
+        return;
+    u32 n = len;
+    if (n > DNS_CAPTURE)
+        n = DNS_CAPTURE;
+    n &= MAX_PAYLOAD - 1;
+    long err = user ? bpf_probe_read_user(pe->payload, n, buf) : bpf_probe_read_kernel(pe->payload, n, buf);
+    if (err)
+        return;
+    fill_common(&pe->ev, EVENT_TYPE_DNS, pid);
+    bpf_ringbuf_output(&events, pe, sizeof(pe->ev) + n, 0);
+}
 
-    if (!filter_pass(pid, EVENT_TYPE_UPROBE))
+// udp_sendmsg и udpv6_sendmsg: адрес назначения и буфер берём на входе,
+// событие — на выходе, когда уже известны локальный порт и результат
+SEC("kprobe/udp_sendmsg")
+int handle_udp_send(struct pt_regs *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    // udpv6_sendmsg для v4-mapped адреса вызывает udp_sendmsg: событие одно
+    if (bpf_map_lookup_elem(&udp_inflight, &id))
+        return 0;
+    if (!udp_wanted(id >> 32))
         return 0;
+    struct msghdr *msg = (struct msghdr *)PT_REGS_PARM2(ctx);
+    struct udp_send_args a = {};
+    a.sk = PT_REGS_PARM1(ctx);
+    a.buf = msg_user_buf(msg);
+    void *name = BPF_CORE_READ(msg, msg_name);
+    if (name) {
+        u16 family = 0;
+        bpf_probe_read_kernel(&family, sizeof(family), name);
+        if (family == AF_INET) {
+            struct sockaddr_in sin;
+            bpf_probe_read_kernel(&sin, sizeof(sin), name);
+            store_addr4(a.daddr, sin.sin_addr.s_addr);
+            a.dport = bpf_ntohs(sin.sin_port);
+            a.has_dest = 1;
+        } else if (family == AF_INET6) {
+            struct sockaddr_in6 sin6;
+            bpf_probe_read_kernel(&sin6, sizeof(sin6), name);
+            __builtin_memcpy(a.daddr, &sin6.sin6_addr, 16);
+            a.dport = bpf_ntohs(sin6.sin6_port);
+            a.has_dest = 1;
+        }
+    }
+    bpf_map_update_elem(&udp_inflight, &id, &a, BPF_ANY);
+    return 0;
+}
 
-    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
-    if (!e) return 0;
+SEC("kretprobe/udp_sendmsg")
+int handle_udp_send_ret(struct pt_regs *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct udp_send_args *stashed = bpf_map_lookup_elem(&udp_inflight, &id);
+    if (!stashed)
+        return 0;
+    struct udp_send_args a = *stashed;
+    bpf_map_delete_elem(&udp_inflight, &id);
+    int ret = PT_REGS_RC(ctx);
+    if (ret < 0)
+        return 0;
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe)
+        return 0;
+    fill_tcp(&pe->ev, (struct sock *)a.sk, TCP_DIR_OUT);
+    if (a.has_dest) {
+        __builtin_memcpy(pe->ev.conn.daddr, a.daddr, 16);
+        pe->ev.conn.dport = a.dport;
+    }
+    pe->ev.conn.len = ret;
+    submit_udp(pe, id >> 32, (void *)a.buf, 1, ret);
+    return 0;
+}
 
-    e->type = EVENT_TYPE_UPROBE;
-    e->pid = pid;
-    e->tgid = bpf_get_current_pid_tgid();
-    e->timestamp = bpf_ktime_get_ns();
-    bpf_get_current_comm(&e->comm, sizeof(e->comm));
+// Приём: skb_consume_udp вызывают udp_recvmsg и udpv6_recvmsg после копирования
+// данных; адреса берём из заголовков пакета, skb->data уже указывает на payload
+SEC("kprobe/skb_consume_udp")
+int handle_udp_recv(struct pt_regs *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    int len = (int)PT_REGS_PARM3(ctx);
+    if (len < 0 || !udp_wanted(pid))
+        return 0;
+    struct sk_buff *skb = (struct sk_buff *)PT_REGS_PARM2(ctx);
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe)
+        return 0;
 
-    // Запишем имя функции из map
-    __builtin_memset(e->uprobe.func, 0, sizeof(e->uprobe.func));
-    bpf_probe_read_kernel_str(e->uprobe.func, sizeof(e->uprobe.func), func_name);
+    unsigned char *head = BPF_CORE_READ(skb, head);
+    u16 nh = BPF_CORE_READ(skb, network_header);
+    u16 th = BPF_CORE_READ(skb, transport_header);
+    u8 version = 0;
+    bpf_probe_read_kernel(&version, sizeof(version), head + nh);
+    version >>= 4;
+    if (version == 4) {
+        struct iphdr ip;
+        bpf_probe_read_kernel(&ip, sizeof(ip), head + nh);
+        store_addr4(pe->ev.conn.saddr, ip.saddr);
+        store_addr4(pe->ev.conn.daddr, ip.daddr);
+        pe->ev.conn.family = AF_INET;
+    } else if (version == 6) {
+        struct ipv6hdr ip6;
+        bpf_probe_read_kernel(&ip6, sizeof(ip6), head + nh);
+        __builtin_memcpy(pe->ev.conn.saddr, &ip6.saddr, 16);
+        __builtin_memcpy(pe->ev.conn.daddr, &ip6.daddr, 16);
+        pe->ev.conn.family = AF_INET6;
+    } else {
+        return 0;
+    }
+    struct udphdr uh;
+    bpf_probe_read_kernel(&uh, sizeof(uh), head + th);
+    pe->ev.conn.sport = bpf_ntohs(uh.source);
+    pe->ev.conn.dport = bpf_ntohs(uh.dest);
+    pe->ev.conn.direction = TCP_DIR_IN;
+    pe->ev.conn.len = len;
 
-    // Аргументы — первые 4 (для x86_64 и aarch64)
-#if defined(__x86_64__) || defined(__aarch64__)
-    e->uprobe.args[0] = PT_REGS_PARM1(ctx);
-    e->uprobe.args[1] = PT_REGS_PARM2(ctx);
-    e->uprobe.args[2] = PT_REGS_PARM3(ctx);
-    e->uprobe.args[3] = PT_REGS_PARM4(ctx);
-#else
-    #pragma unroll
-    for (int i = 0; i < 4; i++)
-        e->uprobe.args[i] = 0;
-#endif
+    // Только линейная часть skb; DNS-ответы в неё помещаются
+    u32 headlen = BPF_CORE_READ(skb, len) - BPF_CORE_READ(skb, data_len);
+    u32 n = len;
+    if (n > headlen)
+        n = headlen;
+    submit_udp(pe, pid, BPF_CORE_READ(skb, data), 0, n);
+    return 0;
+}
 
-    bpf_ringbuf_submit(e, 0);
+// =========== PROCESS LIFECYCLE ===========
+
+// fork, vfork, clone и clone3 (процессы и потоки). Если включено следование,
+// новый процесс наследует маску событий родителя (аналог strace -f)
+SEC("tp_btf/sched_process_fork")
+int BPF_PROG(handle_sched_fork, struct task_struct *parent, struct task_struct *child) {
+    u32 parent_pid = BPF_CORE_READ(parent, tgid);
+    u32 child_pid = BPF_CORE_READ(child, tgid);
+    u32 child_tid = BPF_CORE_READ(child, pid);
+    u32 thread = child_tid != child_pid;
+
+    // Потоки делят TGID родителя и уже под фильтром
+    if (!thread && get_config(CONFIG_FOLLOW_CHILDREN)) {
+        u32 *mask = bpf_map_lookup_elem(&pid_filters, &parent_pid);
+        if (mask) {
+            u32 inherited = *mask;
+            if (bpf_map_update_elem(&pid_filters, &child_pid, &inherited, BPF_ANY)) {
+                u32 zero = 0;
+                u64 *lost = bpf_map_lookup_elem(&follow_lost, &zero);
+                if (lost)
+                    __sync_fetch_and_add(lost, 1);
+            }
+        }
+    }
+
+    // Жизненный цикл процессов шлём и без фильтров: иначе дерево в Go не
+    // увидит выхода чужих процессов и после переиспользования PID соврёт.
+    // Чужие потоки дереву не нужны
+    int pass = filter_pass(parent_pid, EVENT_TYPE_CLONE);
+    if (!pass && thread)
+        return 0;
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_CLONE, parent_pid);
+    if (!pass)
+        e->type |= EVENT_FLAG_HIDDEN;
+    e->clone.parent_pid = parent_pid;
+    e->clone.child_pid = child_pid;
+    e->clone.child_tid = child_tid;
+    e->clone.thread = thread;
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// Успешный execve/execveat: имя файла и comm уже новые
+SEC("tracepoint/sched/sched_process_exec")
+int handle_sched_exec(struct trace_event_raw_sched_process_exec *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    // Не прошедший фильтры exec тоже нужен дереву процессов
+    int pass = filter_pass(pid, EVENT_TYPE_EXECVE);
+    unsigned int off = ctx->__data_loc_filename & 0xFFFF;
+    const char *path = path_checked((void *)ctx + off, 0);
+    if (!path) {
+        pass = 0;
+        path = (void *)ctx + off;
+    }
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_EXECVE, pid);
+    if (!pass)
+        e->type |= EVENT_FLAG_HIDDEN;
+    bpf_probe_read_kernel_str(e->execve.filename, sizeof(e->execve.filename), path);
+    e->execve.old_pid = ctx->old_pid;
+    e->execve.ppid = BPF_CORE_READ(task, real_parent, tgid);
+    // cred уже от нового образа: у setuid/setgid бинаря euid/egid отличаются от uid/gid
+    e->execve.euid = BPF_CORE_READ(task, cred, euid.val);
+    e->execve.egid = BPF_CORE_READ(task, cred, egid.val);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// Текущий поток — последний живой в своей группе
+static __always_inline int group_dead(void) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    return BPF_CORE_READ(task, signal, live.counter) == 0;
+}
+
+// Выход каждого потока, включая exit(2) без exit_group и смерть от сигнала
+SEC("tracepoint/sched/sched_process_exit")
+int handle_sched_exit(struct trace_event_raw_sched_process_template *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    // Процесс завершён, когда вышел последний поток, а не лидер:
+    // лидер может уйти через pthread_exit раньше остальных
+    int dead = group_dead();
+
+    // Выход процесса шлём и без фильтров — для дерева процессов
+    int pass = filter_pass(pid, EVENT_TYPE_EXIT);
+    if (pass || dead) {
+        struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+        struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+        if (e) {
+            fill_common(e, EVENT_TYPE_EXIT, pid);
+            if (!pass)
+                e->type |= EVENT_FLAG_HIDDEN;
+            int code = BPF_CORE_READ(task, exit_code);
+            e->exit.exit_code = (code >> 8) & 0xff;
+            e->exit.signal = code & 0x7f;
+            e->exit.core_dumped = (code & 0x80) != 0;
+            e->exit.thread = !dead;
+            e->exit.ppid = BPF_CORE_READ(task, real_parent, tgid);
+            // Для потока — время жизни потока, для процесса — от старта лидера
+            u64 start = dead ? BPF_CORE_READ(task, group_leader, start_time)
+                             : BPF_CORE_READ(task, start_time);
+            e->exit.runtime_ns = bpf_ktime_get_ns() - start;
+            bpf_ringbuf_submit(e, 0);
+        }
+    }
+
+    // Запись фильтра убираем, только когда вышел последний поток группы:
+    // лидер может уйти через pthread_exit, а остальные потоки — работать и
+    // порождать детей. signal->live уменьшен в do_exit до этого tracepoint-а
+    if (get_config(CONFIG_FOLLOW_CHILDREN) && dead)
+        bpf_map_delete_elem(&pid_filters, &pid);
+    return 0;
+}
+
+// =========== SIGNALS ===========
+
+SEC("tp_btf/signal_generate")
+int BPF_PROG(handle_signal_generate, int sig, struct kernel_siginfo *info,
+             struct task_struct *task, int group, int result) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u32 target = BPF_CORE_READ(task, tgid);
+    if (!target_pass(pid, target, EVENT_TYPE_SIGNAL))
+        return 0;
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_SIGNAL, pid);
+    e->signal.target_pid = target;
+    e->signal.target_tid = BPF_CORE_READ(task, pid);
+    e->signal.sig = sig;
+    // SEND_SIG_NOINFO/SEND_SIG_PRIV — маленькие числа вместо указателя
+    e->signal.code = (unsigned long)info > 1 ? BPF_CORE_READ(info, si_code) : 0;
+    e->signal.result = result;
+    e->signal.source = SIGNAL_GENERATE;
+    e->signal.sender_pid = pid;
+    BPF_CORE_READ_STR_INTO(&e->signal.target_comm, task, group_leader, comm);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+SEC("tp_btf/signal_deliver")
+int BPF_PROG(handle_signal_deliver, int sig, struct kernel_siginfo *info, struct k_sigaction *ka) {
+    u64 id = bpf_get_current_pid_tgid();
+    u32 pid = id >> 32;
+    if (!filter_pass(pid, EVENT_TYPE_SIGNAL))
+        return 0;
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_SIGNAL, pid);
+    e->signal.target_pid = pid;
+    e->signal.target_tid = (u32)id;
+    e->signal.sig = sig;
+    e->signal.code = 0;
+    e->signal.sender_pid = 0;
+    if ((unsigned long)info > 1) {
+        e->signal.code = BPF_CORE_READ(info, si_code);
+        // si_pid есть только у сигналов от процессов (kill, sigqueue, tgkill)
+        if (e->signal.code <= 0)
+            e->signal.sender_pid = BPF_CORE_READ(info, _sifields._kill._pid);
+    }
+    e->signal.result = 0;
+    e->signal.source = SIGNAL_DELIVER;
+    BPF_CORE_READ_STR_INTO(&e->signal.target_comm, task, group_leader, comm);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// kill/tgkill/tkill: аргументы на входе, результат на выходе
+SEC("tracepoint/syscalls/sys_enter_kill")
+int handle_kill(struct trace_event_raw_sys_enter *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (target_pass(pid, (u32)ctx->args[0], EVENT_TYPE_SIGNAL))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_tgkill")
+int handle_tgkill(struct trace_event_raw_sys_enter *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (target_pass(pid, (u32)ctx->args[0], EVENT_TYPE_SIGNAL))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_tkill")
+int handle_tkill(struct trace_event_raw_sys_enter *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (filter_pass(pid, EVENT_TYPE_SIGNAL))
+        stash_args(ctx);
+    return 0;
+}
+
+static __always_inline int submit_kill(struct trace_event_raw_sys_exit *ctx, u32 source) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_SIGNAL, pid);
+    e->signal.target_pid = 0;
+    e->signal.target_tid = 0;
+    switch (source) {
+    case SIGNAL_KILL:
+        e->signal.target_pid = (u32)a.args[0];
+        e->signal.sig = (int)a.args[1];
+        break;
+    case SIGNAL_TGKILL:
+        e->signal.target_pid = (u32)a.args[0];
+        e->signal.target_tid = (u32)a.args[1];
+        e->signal.sig = (int)a.args[2];
+        break;
+    default:
+        e->signal.target_tid = (u32)a.args[0];
+        e->signal.sig = (int)a.args[1];
+    }
+    e->signal.code = 0;
+    e->signal.result = (int)ctx->ret;
+    e->signal.source = source;
+    e->signal.sender_pid = pid;
+    e->signal.target_comm[0] = 0;
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_kill")
+int handle_kill_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_kill(ctx, SIGNAL_KILL);
+}
+
+SEC("tracepoint/syscalls/sys_exit_tgkill")
+int handle_tgkill_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_kill(ctx, SIGNAL_TGKILL);
+}
+
+SEC("tracepoint/syscalls/sys_exit_tkill")
+int handle_tkill_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_kill(ctx, SIGNAL_TKILL);
+}
+
+// =========== KERNEL CODE LOADING ===========
+
+// Имя модуля из module_load, до выхода из init_module/finit_module
+struct module_name {
+    char name[MODULE_NAME_LEN];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u64);
+    __type(value, struct module_name);
+} module_names SEC(".maps");
+
+#define MAX_PATH_DEPTH 16
+#define PATH_COMPONENT 256
+
+// struct file за fd текущего процесса
+static __always_inline struct file *fd_file(int fd) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct fdtable *fdt = BPF_CORE_READ(task, files, fdt);
+    if (fd < 0 || (u32)fd >= BPF_CORE_READ(fdt, max_fds))
+        return NULL;
+    struct file **fds = BPF_CORE_READ(fdt, fd);
+    struct file *f = NULL;
+    bpf_probe_read_kernel(&f, sizeof(f), &fds[fd]);
+    return f;
+}
+
+// Путь файла: имена dentry от файла вверх до корня его файловой системы,
+// каждое с '\0' (собрать путь в прямом порядке в BPF дорого, разворачивает userspace).
+// Возвращает число записанных байт
+static __always_inline u32 file_path_components(struct file *f, u8 *buf) {
+    if (!f)
+        return 0;
+    struct dentry *d = BPF_CORE_READ(f, f_path.dentry);
+    u32 off = 0;
+    for (int i = 0; i < MAX_PATH_DEPTH; i++) {
+        struct dentry *parent = BPF_CORE_READ(d, d_parent);
+        if (!parent || parent == d || off >= MAX_PAYLOAD / 2)
+            break;
+        const unsigned char *name = BPF_CORE_READ(d, d_name.name);
+        long n = bpf_probe_read_kernel_str(buf + (off & (MAX_PAYLOAD / 2 - 1)), PATH_COMPONENT, name);
+        if (n <= 0)
+            break;
+        off += n;
+        d = parent;
+    }
+    return off;
+}
+
+SEC("tracepoint/syscalls/sys_enter_init_module")
+int handle_init_module(struct trace_event_raw_sys_enter *ctx) {
+    if (filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MODULE))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_finit_module")
+int handle_finit_module(struct trace_event_raw_sys_enter *ctx) {
+    if (filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MODULE))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_delete_module")
+int handle_delete_module(struct trace_event_raw_sys_enter *ctx) {
+    if (filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MODULE))
+        stash_args(ctx);
+    return 0;
+}
+
+// Модуль разобран и вот-вот будет инициализирован; запоминаем имя до выхода из syscall-а
+SEC("tracepoint/module/module_load")
+int handle_module_load(struct trace_event_raw_module_load *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    if (!bpf_map_lookup_elem(&inflight, &id))
+        return 0;
+    struct module_name m = {};
+    u16 off = ctx->__data_loc_name & 0xFFFF;
+    bpf_probe_read_kernel_str(m.name, sizeof(m.name), (void *)ctx + off);
+    bpf_map_update_elem(&module_names, &id, &m, BPF_ANY);
+    return 0;
+}
+
+static __always_inline int submit_module(struct trace_event_raw_sys_exit *ctx, u32 op) {
+    struct syscall_args a;
+    u64 id = bpf_get_current_pid_tgid();
+    if (!pop_args(ctx, &a)) {
+        bpf_map_delete_elem(&module_names, &id);
+        return 0;
+    }
+    struct module_name *m = bpf_map_lookup_elem(&module_names, &id);
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe) {
+        bpf_map_delete_elem(&module_names, &id);
+        return 0;
+    }
+    fill_common(&pe->ev, EVENT_TYPE_MODULE, id >> 32);
+    pe->ev.module.name[0] = 0;
+    pe->ev.module.op = op;
+    pe->ev.module.ret = (int)ctx->ret;
+    pe->ev.module.fd = -1;
+    pe->ev.module.flags = 0;
+    u32 n = 0;
+    switch (op) {
+    case MODULE_DELETE:
+        bpf_probe_read_user_str(pe->ev.module.name, sizeof(pe->ev.module.name), (void *)a.args[0]);
+        pe->ev.module.flags = (u32)a.args[1];
+        break;
+    case MODULE_FINIT:
+        pe->ev.module.fd = (int)a.args[0];
+        pe->ev.module.flags = (u32)a.args[2];
+        n = file_path_components(fd_file(pe->ev.module.fd), pe->payload);
+        break;
+    }
+    // Имени нет, если загрузка сорвалась до разбора модуля
+    if (m) {
+        __builtin_memcpy(pe->ev.module.name, m->name, sizeof(pe->ev.module.name));
+        bpf_map_delete_elem(&module_names, &id);
+    }
+    n &= MAX_PAYLOAD - 1;
+    bpf_ringbuf_output(&events, pe, sizeof(pe->ev) + n, 0);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_init_module")
+int handle_init_module_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_module(ctx, MODULE_INIT);
+}
+
+SEC("tracepoint/syscalls/sys_exit_finit_module")
+int handle_finit_module_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_module(ctx, MODULE_FINIT);
+}
+
+SEC("tracepoint/syscalls/sys_exit_delete_module")
+int handle_delete_module_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_module(ctx, MODULE_DELETE);
+}
+
+// bpf(): только команды, загружающие или подключающие код; lookup/update map-ов
+// идут потоком и здесь не нужны
+static __always_inline int bpf_cmd_wanted(int cmd) {
+    switch (cmd) {
+    case BPF_MAP_CREATE:
+    case BPF_PROG_LOAD:
+    case BPF_PROG_ATTACH:
+    case BPF_RAW_TRACEPOINT_OPEN:
+    case BPF_BTF_LOAD:
+    case BPF_LINK_CREATE:
+        return 1;
+    }
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_bpf")
+int handle_bpf(struct trace_event_raw_sys_enter *ctx) {
+    if (bpf_cmd_wanted((int)ctx->args[0]) &&
+        filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_BPF))
+        stash_args(ctx);
+    return 0;
+}
+
+#define BPF_OBJ_NAME_LEN 16
+
+// Смещения в union bpf_attr — часть ABI и не меняются
+SEC("tracepoint/syscalls/sys_exit_bpf")
+int handle_bpf_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    u8 *attr = (u8 *)a.args[1];
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_BPF, bpf_get_current_pid_tgid() >> 32);
+    e->bpf.cmd = (int)a.args[0];
+    e->bpf.ret = (int)ctx->ret;
+    e->bpf.type = 0;
+    e->bpf.insn_cnt = 0;
+    e->bpf.prog_fd = -1;
+    e->bpf.name[0] = 0;
+    switch (e->bpf.cmd) {
+    case BPF_MAP_CREATE:
+        bpf_probe_read_user(&e->bpf.type, sizeof(u32), attr);           // map_type
+        bpf_probe_read_user(e->bpf.name, BPF_OBJ_NAME_LEN, attr + 28);  // map_name
+        break;
+    case BPF_PROG_LOAD:
+        bpf_probe_read_user(&e->bpf.type, sizeof(u32), attr);           // prog_type
+        bpf_probe_read_user(&e->bpf.insn_cnt, sizeof(u32), attr + 4);
+        bpf_probe_read_user(e->bpf.name, BPF_OBJ_NAME_LEN, attr + 48);  // prog_name
+        break;
+    case BPF_PROG_ATTACH:
+        bpf_probe_read_user(&e->bpf.prog_fd, sizeof(int), attr + 4);    // attach_bpf_fd
+        bpf_probe_read_user(&e->bpf.type, sizeof(u32), attr + 8);       // attach_type
+        break;
+    case BPF_LINK_CREATE:
+        bpf_probe_read_user(&e->bpf.prog_fd, sizeof(int), attr);
+        bpf_probe_read_user(&e->bpf.type, sizeof(u32), attr + 8);       // attach_type
+        break;
+    case BPF_RAW_TRACEPOINT_OPEN: {
+        u64 name = 0;
+        bpf_probe_read_user(&name, sizeof(name), attr);
+        bpf_probe_read_user(&e->bpf.prog_fd, sizeof(int), attr + 8);
+        if (name)
+            bpf_probe_read_user_str(e->bpf.name, sizeof(e->bpf.name), (void *)name);
+        break;
+    }
+    }
+    // Имена программ и map-ов не обязаны заканчиваться нулём
+    e->bpf.name[sizeof(e->bpf.name) - 1] = 0;
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// =========== EXECUTABLE MAPPINGS ===========
+
+#define PROT_EXEC 0x4
+
+// VMA, которую меняет mprotect (из security_file_mprotect), до выхода из syscall-а
+struct mprotect_vma {
+    u64 vm_flags;
+    u64 file;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct mprotect_vma);
+} mprotect_vmas SEC(".maps");
+
+SEC("tracepoint/syscalls/sys_enter_mmap")
+int handle_mmap(struct trace_event_raw_sys_enter *ctx) {
+    if ((ctx->args[2] & PROT_EXEC) &&
+        filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MMAP_EXEC))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_mprotect")
+int handle_mprotect(struct trace_event_raw_sys_enter *ctx) {
+    if ((ctx->args[2] & PROT_EXEC) &&
+        filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MMAP_EXEC))
+        stash_args(ctx);
+    return 0;
+}
+
+// security_file_mprotect(vma, reqprot, prot) вызывается для каждой VMA диапазона;
+// берём первую — этого хватает, чтобы увидеть переход W→X и файл за регионом
+SEC("kprobe/security_file_mprotect")
+int handle_file_mprotect(struct pt_regs *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    if (!bpf_map_lookup_elem(&inflight, &id))
+        return 0;
+    struct vm_area_struct *vma = (struct vm_area_struct *)PT_REGS_PARM1(ctx);
+    struct mprotect_vma v = {
+        .vm_flags = BPF_CORE_READ(vma, vm_flags),
+        .file = (u64)BPF_CORE_READ(vma, vm_file),
+    };
+    bpf_map_update_elem(&mprotect_vmas, &id, &v, BPF_NOEXIST);
+    return 0;
+}
+
+static __always_inline int submit_mmap(struct trace_event_raw_sys_exit *ctx, u32 op) {
+    struct syscall_args a;
+    u64 id = bpf_get_current_pid_tgid();
+    // Запись от чужого syscall-а: иначе её прочтёт следующий mprotect потока
+    if (!pop_args(ctx, &a)) {
+        bpf_map_delete_elem(&mprotect_vmas, &id);
+        return 0;
+    }
+    struct mprotect_vma *v = bpf_map_lookup_elem(&mprotect_vmas, &id);
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe) {
+        bpf_map_delete_elem(&mprotect_vmas, &id);
+        return 0;
+    }
+    fill_common(&pe->ev, EVENT_TYPE_MMAP_EXEC, id >> 32);
+    pe->ev.mmap.op = op;
+    pe->ev.mmap.len = a.args[1];
+    pe->ev.mmap.prot = (u32)a.args[2];
+    pe->ev.mmap.ret = ctx->ret;
+    pe->ev.mmap.vm_flags = 0;
+    struct file *f = NULL;
+    if (op == MMAP_OP_MMAP) {
+        // адрес — результат mmap, аргумент addr только подсказка
+        pe->ev.mmap.addr = ctx->ret < 0 ? a.args[0] : (u64)ctx->ret;
+        pe->ev.mmap.flags = (u32)a.args[3];
+        pe->ev.mmap.fd = (int)a.args[4];
+        f = fd_file(pe->ev.mmap.fd);
+    } else {
+        pe->ev.mmap.addr = a.args[0];
+        pe->ev.mmap.flags = 0;
+        pe->ev.mmap.fd = -1;
+        if (v) {
+            pe->ev.mmap.vm_flags = v->vm_flags;
+            f = (struct file *)v->file;
+        }
+    }
+    if (v)
+        bpf_map_delete_elem(&mprotect_vmas, &id);
+    u32 n = file_path_components(f, pe->payload);
+    n &= MAX_PAYLOAD - 1;
+    bpf_ringbuf_output(&events, pe, sizeof(pe->ev) + n, 0);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_mmap")
+int handle_mmap_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_mmap(ctx, MMAP_OP_MMAP);
+}
+
+SEC("tracepoint/syscalls/sys_exit_mprotect")
+int handle_mprotect_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_mmap(ctx, MMAP_OP_MPROTECT);
+}
+
+// =========== NAMESPACES AND MOUNTS ===========
+
+// setns/unshare между входом и выходом: namespace-ы до вызова
+struct ns_args {
+    u32 op;
+    int fd;
+    u64 flags;
+    struct ns_inums before;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, u64);
+    __type(value, struct ns_args);
+} ns_inflight SEC(".maps");
+
+static __always_inline void read_ns(struct task_struct *task, struct ns_inums *ns) {
+    struct nsproxy *p = BPF_CORE_READ(task, nsproxy);
+    ns->mnt = BPF_CORE_READ(p, mnt_ns, ns.inum);
+    ns->pid = BPF_CORE_READ(p, pid_ns_for_children, ns.inum);
+    ns->net = BPF_CORE_READ(p, net_ns, ns.inum);
+    ns->uts = BPF_CORE_READ(p, uts_ns, ns.inum);
+    ns->ipc = BPF_CORE_READ(p, ipc_ns, ns.inum);
+    ns->cgroup = BPF_CORE_READ(p, cgroup_ns, ns.inum);
+    ns->user = BPF_CORE_READ(task, cred, user_ns, ns.inum);
+    ns->time = 0;
+    if (bpf_core_field_exists(p->time_ns_for_children))
+        ns->time = BPF_CORE_READ(p, time_ns_for_children, ns.inum);
+}
+
+static __always_inline int stash_ns(u32 op, int fd, u64 flags) {
+    u64 id = bpf_get_current_pid_tgid();
+    if (!filter_pass(id >> 32, EVENT_TYPE_NAMESPACE))
+        return 0;
+    struct ns_args a = { .op = op, .fd = fd, .flags = flags };
+    read_ns((struct task_struct *)bpf_get_current_task(), &a.before);
+    bpf_map_update_elem(&ns_inflight, &id, &a, BPF_ANY);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_setns")
+int handle_setns(struct trace_event_raw_sys_enter *ctx) {
+    return stash_ns(NS_OP_SETNS, (int)ctx->args[0], ctx->args[1]);
+}
+
+SEC("tracepoint/syscalls/sys_enter_unshare")
+int handle_unshare(struct trace_event_raw_sys_enter *ctx) {
+    return stash_ns(NS_OP_UNSHARE, -1, ctx->args[0]);
+}
+
+// Общий выход для setns и unshare
+SEC("tracepoint/syscalls/sys_exit_ns")
+int handle_ns_exit(struct trace_event_raw_sys_exit *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct ns_args *a = bpf_map_lookup_elem(&ns_inflight, &id);
+    if (!a)
+        return 0;
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        bpf_map_delete_elem(&ns_inflight, &id);
+        return 0;
+    }
+    fill_common(e, EVENT_TYPE_NAMESPACE, id >> 32);
+    e->ns.op = a->op;
+    e->ns.ret = (int)ctx->ret;
+    e->ns.flags = a->flags;
+    e->ns.fd = a->fd;
+    e->ns.target = 0;
+    if (a->op == NS_OP_SETNS) {
+        struct file *f = fd_file(a->fd);
+        if (f)
+            e->ns.target = BPF_CORE_READ(f, f_inode, i_ino);
+    }
+    e->ns.before = a->before;
+    read_ns((struct task_struct *)bpf_get_current_task(), &e->ns.after);
+    bpf_map_delete_elem(&ns_inflight, &id);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_mount")
+int handle_mount(struct trace_event_raw_sys_enter *ctx) {
+    if (filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MOUNT))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_umount")
+int handle_umount(struct trace_event_raw_sys_enter *ctx) {
+    if (filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MOUNT))
+        stash_args(ctx);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_pivot_root")
+int handle_pivot_root(struct trace_event_raw_sys_enter *ctx) {
+    if (filter_pass(bpf_get_current_pid_tgid() >> 32, EVENT_TYPE_MOUNT))
+        stash_args(ctx);
+    return 0;
+}
+
+#define MOUNT_SOURCE_LEN 256
+
+static __always_inline int submit_mount(struct trace_event_raw_sys_exit *ctx, u32 op) {
+    struct syscall_args a;
+    if (!pop_args(ctx, &a))
+        return 0;
+    u32 zero = 0;
+    struct payload_event *pe = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!pe)
+        return 0;
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    fill_common(&pe->ev, EVENT_TYPE_MOUNT, bpf_get_current_pid_tgid() >> 32);
+    pe->ev.mount.op = op;
+    pe->ev.mount.ret = (int)ctx->ret;
+    pe->ev.mount.flags = 0;
+    pe->ev.mount.mnt_ns = BPF_CORE_READ(task, nsproxy, mnt_ns, ns.inum);
+    pe->ev.mount.fstype[0] = 0;
+    pe->ev.mount.target[0] = 0;
+    u64 target = 0, source = 0;
+    switch (op) {
+    case MOUNT_OP_MOUNT:   // mount(source, target, fstype, flags, data)
+        source = a.args[0];
+        target = a.args[1];
+        pe->ev.mount.flags = a.args[3];
+        if (a.args[2])
+            bpf_probe_read_user_str(pe->ev.mount.fstype, sizeof(pe->ev.mount.fstype), (void *)a.args[2]);
+        break;
+    case MOUNT_OP_UMOUNT:  // umount2(target, flags)
+        target = a.args[0];
+        pe->ev.mount.flags = a.args[1];
+        break;
+    default:               // pivot_root(new_root, put_old)
+        target = a.args[0];
+        source = a.args[1];
+    }
+    if (target)
+        bpf_probe_read_user_str(pe->ev.mount.target, sizeof(pe->ev.mount.target), (void *)target);
+    long n = 0;
+    if (source) {
+        n = bpf_probe_read_user_str(pe->payload, MOUNT_SOURCE_LEN, (void *)source);
+        if (n < 0)
+            n = 0;
+    }
+    n &= MAX_PAYLOAD - 1;
+    bpf_ringbuf_output(&events, pe, sizeof(pe->ev) + n, 0);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_mount")
+int handle_mount_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_mount(ctx, MOUNT_OP_MOUNT);
+}
+
+SEC("tracepoint/syscalls/sys_exit_umount")
+int handle_umount_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_mount(ctx, MOUNT_OP_UMOUNT);
+}
+
+SEC("tracepoint/syscalls/sys_exit_pivot_root")
+int handle_pivot_root_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_mount(ctx, MOUNT_OP_PIVOT_ROOT);
+}
+
+// =========== OOM KILLER ===========
+
+// mm->rss_stat: до 6.2 struct mm_rss_stat с atomic_long_t count[], потом массив percpu_counter
+struct mm_rss_stat___x {
+    atomic_long_t count[4];
+} __attribute__((preserve_access_index));
+
+struct mm_struct___old {
+    struct mm_rss_stat___x rss_stat;
+} __attribute__((preserve_access_index));
+
+struct mm_struct___new {
+    struct percpu_counter rss_stat[4];
+} __attribute__((preserve_access_index));
+
+#define MM_FILEPAGES  0
+#define MM_ANONPAGES  1
+#define MM_SHMEMPAGES 3
+
+// Счётчик страниц mm; как и get_mm_counter, приблизительный (percpu/per-thread кэши)
+static __always_inline u64 mm_counter(struct mm_struct *mm, int member) {
+    s64 v = 0;
+    if (bpf_core_field_exists(((struct mm_struct___new *)mm)->rss_stat[0].count))
+        v = BPF_CORE_READ((struct mm_struct___new *)mm, rss_stat[member].count);
+    else
+        v = BPF_CORE_READ((struct mm_struct___old *)mm, rss_stat.count[member].counter);
+    return v < 0 ? 0 : v;
+}
+
+// oom_kill_process(oc, message): жертва уже выбрана в oc->chosen, current — кто упёрся в память
+SEC("kprobe/oom_kill_process")
+int handle_oom_kill(struct pt_regs *ctx) {
+    struct oom_control *oc = (struct oom_control *)PT_REGS_PARM1(ctx);
+    struct task_struct *victim = BPF_CORE_READ(oc, chosen);
+    if (!victim)
+        return 0;
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u32 victim_pid = BPF_CORE_READ(victim, tgid);
+    if (!target_pass(pid, victim_pid, EVENT_TYPE_OOM))
+        return 0;
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_OOM, pid);
+    e->oom.victim_pid = victim_pid;
+    e->oom.oom_score_adj = BPF_CORE_READ(victim, signal, oom_score_adj);
+    BPF_CORE_READ_STR_INTO(&e->oom.victim_comm, victim, comm);
+    e->oom.victim_uid = BPF_CORE_READ(victim, cred, uid.val);
+    struct mm_struct *mm = BPF_CORE_READ(victim, mm);
+    e->oom.anon_rss = mm ? mm_counter(mm, MM_ANONPAGES) : 0;
+    e->oom.file_rss = mm ? mm_counter(mm, MM_FILEPAGES) : 0;
+    e->oom.shmem_rss = mm ? mm_counter(mm, MM_SHMEMPAGES) : 0;
+    e->oom.total_pages = BPF_CORE_READ(oc, totalpages);
+    e->oom.points = BPF_CORE_READ(oc, chosen_points);
+    e->oom.constraint = BPF_CORE_READ(oc, constraint);
+    e->oom.memcg_id = 0;
+    struct mem_cgroup *memcg = BPF_CORE_READ(oc, memcg);
+    if (memcg)
+        e->oom.memcg_id = BPF_CORE_READ(memcg, css.cgroup, kn, id);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// =========== RAW SYSCALLS ===========
+// Программы привязываются только с --syscalls: на каждый syscall в системе
+
+struct raw_syscall {
+    u64 ts;
+    u32 nr;
+    u64 args[6];
+};
+
+// --summary: счётчики вместо событий, Go читает карту целиком
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct syscall_stat_key);
+    __type(value, struct syscall_stat);
+} syscall_stats SEC(".maps");
+
+// Вызовы, не посчитанные из-за переполнения syscall_stats. Записи вышедших
+// процессов не удаляем: их итоги нужны в таблице при выходе
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, u64);
+} syscall_stats_lost SEC(".maps");
+
+// --hist: log2-гистограммы задержек syscall-ов и uprobe-функций
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct hist_key);
+    __type(value, struct hist);
+} latency_hists SEC(".maps");
+
+// Нули для новой гистограммы: struct hist на стек не помещается с запасом
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, struct hist);
+} hist_zero SEC(".maps");
+
+// LRU: exit/exit_group и прерванные exec-ом вызовы выхода не дождутся
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct raw_syscall);
+} raw_inflight SEC(".maps");
+
+static __always_inline u32 log2_u32(u32 v) {
+    u32 r, shift;
+    r = (v > 0xFFFF) << 4; v >>= r;
+    shift = (v > 0xFF) << 3; v >>= shift; r |= shift;
+    shift = (v > 0xF) << 2; v >>= shift; r |= shift;
+    shift = (v > 0x3) << 1; v >>= shift; r |= shift;
+    return r | (v >> 1);
+}
+
+static __always_inline u32 log2_u64(u64 v) {
+    u32 hi = v >> 32;
+    return hi ? log2_u32(hi) + 32 : log2_u32(v);
+}
+
+static __always_inline void hist_record(u32 type, u32 pid, u64 id, u64 duration) {
+    u64 by = get_config(CONFIG_HIST);
+    struct hist_key key = {
+        .type = type,
+        .pid = (by & HIST_BY_PID) ? pid : 0,
+        .id = (by & HIST_BY_NAME) ? id : 0,
+    };
+    struct hist *h = bpf_map_lookup_elem(&latency_hists, &key);
+    if (!h) {
+        u32 zero = 0;
+        struct hist *z = bpf_map_lookup_elem(&hist_zero, &zero);
+        if (!z)
+            return;
+        bpf_map_update_elem(&latency_hists, &key, z, BPF_NOEXIST);
+        h = bpf_map_lookup_elem(&latency_hists, &key);
+        if (!h)
+            return;
+    }
+    u32 slot = log2_u64(duration);
+    if (slot >= HIST_SLOTS)
+        slot = HIST_SLOTS - 1;
+    __sync_fetch_and_add(&h->slots[slot], 1);
+    __sync_fetch_and_add(&h->sum_ns, duration);
+}
+
+static __always_inline void count_syscall(u32 pid, u32 nr, long ret, u64 duration) {
+    struct syscall_stat_key key = { .pid = pid, .nr = nr };
+    struct syscall_stat *st = bpf_map_lookup_elem(&syscall_stats, &key);
+    if (!st) {
+        struct syscall_stat zero = {};
+        bpf_map_update_elem(&syscall_stats, &key, &zero, BPF_NOEXIST);
+        st = bpf_map_lookup_elem(&syscall_stats, &key);
+        if (!st) {
+            u32 zero_key = 0;
+            u64 *lost = bpf_map_lookup_elem(&syscall_stats_lost, &zero_key);
+            if (lost)
+                __sync_fetch_and_add(lost, 1);
+            return;
+        }
+    }
+    __sync_fetch_and_add(&st->count, 1);
+    if (ret < 0 && ret >= -4095)
+        __sync_fetch_and_add(&st->errors, 1);
+    __sync_fetch_and_add(&st->total_ns, duration);
+    // Гонка между CPU может потерять максимум, но не испортить его
+    if (duration > st->max_ns)
+        st->max_ns = duration;
+}
+
+SEC("tracepoint/raw_syscalls/sys_enter")
+int handle_raw_sys_enter(struct trace_event_raw_sys_enter *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    u32 pid = id >> 32;
+    // Свои syscall-ы (чтение ringbuf) породили бы событие на каждое событие
+    if (pid == get_config(CONFIG_TRACER_PID))
+        return 0;
+    u32 nr = (u32)ctx->id;
+    u64 mode = get_config(CONFIG_SYSCALL_FILTER);
+    if (mode != FILTER_OFF && !rule_pass(mode, bpf_map_lookup_elem(&syscall_filters, &nr)))
+        return 0;
+    if (!filter_pass(pid, EVENT_TYPE_SYSCALL))
+        return 0;
+    struct raw_syscall s = { .ts = bpf_ktime_get_ns(), .nr = nr };
+    #pragma unroll
+    for (int i = 0; i < 6; i++)
+        s.args[i] = ctx->args[i];
+    bpf_map_update_elem(&raw_inflight, &id, &s, BPF_ANY);
+    return 0;
+}
+
+SEC("tracepoint/raw_syscalls/sys_exit")
+int handle_raw_sys_exit(struct trace_event_raw_sys_exit *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct raw_syscall *s = bpf_map_lookup_elem(&raw_inflight, &id);
+    if (!s)
+        return 0;
+    // --summary и --hist копят в картах, событий не шлют
+    u64 summary = get_config(CONFIG_SUMMARY), hist = get_config(CONFIG_HIST);
+    if (summary || hist) {
+        u64 duration = bpf_ktime_get_ns() - s->ts;
+        u32 nr = s->nr;
+        bpf_map_delete_elem(&raw_inflight, &id);
+        if (summary)
+            count_syscall(id >> 32, nr, ctx->ret, duration);
+        if (hist)
+            hist_record(EVENT_TYPE_SYSCALL, id >> 32, nr, duration);
+        return 0;
+    }
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        bpf_map_delete_elem(&raw_inflight, &id);
+        return 0;
+    }
+    fill_common(e, EVENT_TYPE_SYSCALL, id >> 32);
+    fill_stacks(ctx, e);
+    e->syscall.nr = s->nr;
+    e->syscall.pad = 0;
+    __builtin_memcpy(e->syscall.args, s->args, sizeof(e->syscall.args));
+    e->syscall.ret = ctx->ret;
+    e->syscall.duration_ns = bpf_ktime_get_ns() - s->ts;
+    bpf_map_delete_elem(&raw_inflight, &id);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// =========== BLOCK I/O ===========
+// Запрос от вставки в очередь (или выдачи драйверу) до завершения. Завершение
+// приходит из прерывания, поэтому процесс запоминаем в начале. Непрошедшие
+// фильтры запросы тоже запоминаем: иначе выдачу из kworker-а приняли бы за
+// новый запрос. Программы привязываются, только если block есть в --events
+
+struct block_key {
+    u32 dev;
+    u32 pad;
+    u64 sector;
+};
+
+struct block_start {
+    u64 queued_ts;   // block_rq_insert; 0 — запрос сразу ушёл драйверу
+    u64 issued_ts;
+    u32 bytes;
+    u32 traced;      // 0 — процесс не прошёл фильтры, запрос только отслеживаем
+    u32 pid;
+    u32 tid;
+    u32 ns_pid;
+    u32 uid;
+    u32 gid;
+    u32 pad;
+    u64 cgroup_id;
+    char comm[16];
+    char thread_comm[16];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct block_key);
+    __type(value, struct block_start);
+} block_inflight SEC(".maps");
+
+static __always_inline void block_rq_start(struct trace_event_raw_block_rq *ctx, int issue) {
+    struct block_key key = { .dev = ctx->dev, .sector = ctx->sector };
+    u64 now = bpf_ktime_get_ns();
+    struct block_start *s = bpf_map_lookup_elem(&block_inflight, &key);
+    if (s) {
+        // Уже видели при вставке — отправитель известен, здесь может быть kworker
+        if (issue) {
+            s->issued_ts = now;
+            s->bytes = ctx->bytes;
+        }
+        return;
+    }
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    u64 id = bpf_get_current_pid_tgid();
+    u64 uid_gid = bpf_get_current_uid_gid();
+    struct block_start n = {
+        .bytes = ctx->bytes,
+        .pid = id >> 32,
+        .tid = id,
+        .uid = uid_gid,
+        .gid = uid_gid >> 32,
+        .cgroup_id = bpf_get_current_cgroup_id(),
+    };
+    if (issue)
+        n.issued_ts = now;
+    else
+        n.queued_ts = now;
+    n.traced = filter_pass(n.pid, EVENT_TYPE_BLOCK_IO);
+    n.ns_pid = task_ns_pid(task);
+    BPF_CORE_READ_STR_INTO(&n.comm, task, group_leader, comm);
+    bpf_get_current_comm(&n.thread_comm, sizeof(n.thread_comm));
+    bpf_map_update_elem(&block_inflight, &key, &n, BPF_ANY);
+}
+
+SEC("tracepoint/block/block_rq_insert")
+int handle_block_rq_insert(struct trace_event_raw_block_rq *ctx) {
+    block_rq_start(ctx, 0);
+    return 0;
+}
+
+SEC("tracepoint/block/block_rq_issue")
+int handle_block_rq_issue(struct trace_event_raw_block_rq *ctx) {
+    block_rq_start(ctx, 1);
+    return 0;
+}
+
+SEC("tracepoint/block/block_rq_complete")
+int handle_block_rq_complete(struct trace_event_raw_block_rq_completion *ctx) {
+    struct block_key key = { .dev = ctx->dev, .sector = ctx->sector };
+    struct block_start *s = bpf_map_lookup_elem(&block_inflight, &key);
+    if (!s)
+        return 0;
+    if (!s->traced) {
+        bpf_map_delete_elem(&block_inflight, &key);
+        return 0;
+    }
+    u64 now = bpf_ktime_get_ns();
+    u64 latency = now - (s->queued_ts ? s->queued_ts : s->issued_ts);
+    // --hist: гистограмма на устройство вместо события
+    if (get_config(CONFIG_HIST)) {
+        hist_record(EVENT_TYPE_BLOCK_IO, s->pid, key.dev, latency);
+        bpf_map_delete_elem(&block_inflight, &key);
+        return 0;
+    }
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        bpf_map_delete_elem(&block_inflight, &key);
+        return 0;
+    }
+    e->type = EVENT_TYPE_BLOCK_IO;
+    e->pid = s->pid;
+    e->tid = s->tid;
+    e->ns_pid = s->ns_pid;
+    e->timestamp = now;
+    e->cgroup_id = s->cgroup_id;
+    e->uid = s->uid;
+    e->gid = s->gid;
+    __builtin_memcpy(e->comm, s->comm, sizeof(e->comm));
+    __builtin_memcpy(e->thread_comm, s->thread_comm, sizeof(e->thread_comm));
+    e->user_stack_id = -1;
+    e->kernel_stack_id = -1;
+    e->block.dev = key.dev;
+    e->block.bytes = s->bytes;
+    e->block.sector = key.sector;
+    e->block.latency_ns = latency;
+    e->block.service_ns = s->issued_ts ? now - s->issued_ts : 0;
+    e->block.error = ctx->error;
+    bpf_probe_read_kernel(e->block.rwbs, sizeof(e->block.rwbs), ctx->rwbs);
+    bpf_map_delete_elem(&block_inflight, &key);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// =========== CREDENTIALS ===========
+
+// kernel_cap_t был u32 cap[2], с 6.3 — u64 val; младшие 8 байт совпадают
+static __always_inline u64 read_caps(const kernel_cap_t *caps) {
+    u64 v = 0;
+    bpf_probe_read_kernel(&v, sizeof(v), caps);
+    return v;
+}
+
+// commit_creds(new): старые credentials ещё в current->cred
+SEC("kprobe/commit_creds")
+int handle_commit_creds(struct pt_regs *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (!filter_pass(pid, EVENT_TYPE_CRED))
+        return 0;
+    struct cred *new = (struct cred *)PT_REGS_PARM1(ctx);
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    const struct cred *old = BPF_CORE_READ(task, cred);
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    e->cred.old_uid = BPF_CORE_READ(old, uid.val);
+    e->cred.old_euid = BPF_CORE_READ(old, euid.val);
+    e->cred.old_gid = BPF_CORE_READ(old, gid.val);
+    e->cred.old_egid = BPF_CORE_READ(old, egid.val);
+    e->cred.new_uid = BPF_CORE_READ(new, uid.val);
+    e->cred.new_euid = BPF_CORE_READ(new, euid.val);
+    e->cred.new_gid = BPF_CORE_READ(new, gid.val);
+    e->cred.new_egid = BPF_CORE_READ(new, egid.val);
+    e->cred.old_caps = read_caps(__builtin_preserve_access_index(&old->cap_effective));
+    e->cred.new_caps = read_caps(__builtin_preserve_access_index(&new->cap_effective));
+    // commit_creds зовут и без смены id/capabilities (keyrings и т.п.) — такое не шлём
+    if (e->cred.old_uid == e->cred.new_uid && e->cred.old_euid == e->cred.new_euid &&
+        e->cred.old_gid == e->cred.new_gid && e->cred.old_egid == e->cred.new_egid &&
+        e->cred.old_caps == e->cred.new_caps) {
+        bpf_ringbuf_discard(e, 0);
+        return 0;
+    }
+    fill_common(e, EVENT_TYPE_CRED, pid);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+#define CAP_OPT_NOAUDIT 0x2
+
+struct cap_args {
+    int cap;
+    u32 opts;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, u64);
+    __type(value, struct cap_args);
+} cap_inflight SEC(".maps");
+
+// Проверки capabilities идут тысячами: шлём только первую для (процесс, cap, результат)
+struct cap_seen_key {
+    u32 pid;
+    int cap;
+    int ret;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 16384);
+    __type(key, struct cap_seen_key);
+    __type(value, u8);
+} cap_seen SEC(".maps");
+
+// cap_capable(cred, ns, cap, opts)
+SEC("kprobe/cap_capable")
+int handle_cap_capable(struct pt_regs *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct cap_args a = {
+        .cap = (int)PT_REGS_PARM3(ctx),
+        .opts = (u32)PT_REGS_PARM4(ctx),
+    };
+    // noaudit-проверки — это «можно ли», а не попытка воспользоваться правом (как и в audit)
+    if (a.opts & CAP_OPT_NOAUDIT)
+        return 0;
+    if (!filter_pass(id >> 32, EVENT_TYPE_CAPABLE))
+        return 0;
+    bpf_map_update_elem(&cap_inflight, &id, &a, BPF_ANY);
+    return 0;
+}
+
+SEC("kretprobe/cap_capable")
+int handle_cap_capable_ret(struct pt_regs *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    struct cap_args *a = bpf_map_lookup_elem(&cap_inflight, &id);
+    if (!a)
+        return 0;
+    struct cap_seen_key key = { .pid = id >> 32, .cap = a->cap, .ret = (int)PT_REGS_RC(ctx) };
+    u32 opts = a->opts;
+    bpf_map_delete_elem(&cap_inflight, &id);
+    u8 one = 1;
+    if (bpf_map_update_elem(&cap_seen, &key, &one, BPF_NOEXIST))
+        return 0;
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0); if (!e) return 0;
+    fill_common(e, EVENT_TYPE_CAPABLE, key.pid);
+    e->capable.cap = key.cap;
+    e->capable.ret = key.ret;
+    e->capable.opts = opts;
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// =====================
+// UNIVERSAL UPROBE HANDLER (из uprobes.bpf.c)
+// =====================
+
+// Функцию uprobe-а программа узнаёт по cookie привязки (ядро 5.15+). На более
+// старых ядрах Go загружает варианты _addr: ключ — адрес входа, он совпадает
+// с адресом из ELF только у не-PIE исполняемых файлов
+
+// --hist: время входа в каждый незавершённый вызов, по потоку, функции и
+// глубине вложенности, чтобы вложенные и рекурсивные вызовы не затирали друг
+// друга. Возвраты идут в обратном порядке, так что uretprobe снимает верхний.
+// Без cookie uretprobe не знает функцию: тогда func в ключе 0 (один стек на
+// поток), а функция берётся из значения
+struct uprobe_call_key {
+    u64 id;      // pid_tgid
+    u64 func;
+    u32 depth;   // в uprobe_depth всегда 0
+    u32 pad;
+};
+struct uprobe_call {
+    u64 ts;
+    u64 func;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct uprobe_call_key);
+    __type(value, struct uprobe_call);
+} uprobe_inflight SEC(".maps");
+
+// Число незавершённых вызовов функции в потоке
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct uprobe_call_key);
+    __type(value, u32);
+} uprobe_depth SEC(".maps");
+
+static __always_inline int uprobe_enter(struct pt_regs *ctx, u64 key_func, u64 func, char *func_name) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+
+    // В режиме --hist событие не шлём, только засекаем время
+    if (get_config(CONFIG_HIST)) {
+        if (!filter_pass(pid, EVENT_TYPE_UPROBE))
+            return 0;
+        struct uprobe_call_key key = { .id = bpf_get_current_pid_tgid(), .func = key_func };
+        u32 *depth = bpf_map_lookup_elem(&uprobe_depth, &key);
+        u32 d = depth ? *depth : 0;
+        u32 next = d + 1;
+        bpf_map_update_elem(&uprobe_depth, &key, &next, BPF_ANY);
+        key.depth = d;
+        struct uprobe_call call = { .ts = bpf_ktime_get_ns(), .func = func };
+        bpf_map_update_elem(&uprobe_inflight, &key, &call, BPF_ANY);
+        return 0;
+    }
+
+    if (!func_name) {
+        // Если имя не нашли — не шлем эвент
+        return 0;
+    }
+
+    if (!filter_pass(pid, EVENT_TYPE_UPROBE))
+        return 0;
+
+    struct event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) return 0;
+
+    fill_common(e, EVENT_TYPE_UPROBE, pid);
+    fill_stacks(ctx, e);
+
+    // Запишем имя функции из map
+    __builtin_memset(e->uprobe.func, 0, sizeof(e->uprobe.func));
+    bpf_probe_read_kernel_str(e->uprobe.func, sizeof(e->uprobe.func), func_name);
+
+    // Аргументы — первые 4 (для x86_64 и aarch64)
+#if defined(__x86_64__) || defined(__aarch64__)
+    e->uprobe.args[0] = PT_REGS_PARM1(ctx);
+    e->uprobe.args[1] = PT_REGS_PARM2(ctx);
+    e->uprobe.args[2] = PT_REGS_PARM3(ctx);
+    e->uprobe.args[3] = PT_REGS_PARM4(ctx);
+#else
+    #pragma unroll
+    for (int i = 0; i < 4; i++)
+        e->uprobe.args[i] = 0;
+#endif
+
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// Выход из функции при --hist: снимаем верхний вызов. Снимаем, даже если
+// фильтры за время вызова перестали пропускать процесс, иначе стек съедет
+static __always_inline void uprobe_return(u64 key_func) {
+    struct uprobe_call_key key = { .id = bpf_get_current_pid_tgid(), .func = key_func };
+    u32 *depth = bpf_map_lookup_elem(&uprobe_depth, &key);
+    if (!depth || *depth == 0)
+        return;
+    u32 d = *depth - 1;
+    if (d == 0)
+        bpf_map_delete_elem(&uprobe_depth, &key);
+    else
+        *depth = d;
+    key.depth = d;
+    struct uprobe_call *call = bpf_map_lookup_elem(&uprobe_inflight, &key);
+    if (!call)
+        return;
+    u64 duration = bpf_ktime_get_ns() - call->ts;
+    u64 func = call->func;
+    bpf_map_delete_elem(&uprobe_inflight, &key);
+    u32 pid = key.id >> 32;
+    if (filter_pass(pid, EVENT_TYPE_UPROBE))
+        hist_record(EVENT_TYPE_UPROBE, pid, func, duration);
+}
+
+SEC("uprobe")
+int handle_generic_uprobe(struct pt_regs *ctx) {
+    u64 func = bpf_get_attach_cookie(ctx);
+    return uprobe_enter(ctx, func, func, bpf_map_lookup_elem(&uprobe_configs, &func));
+}
+
+// uretprobe привязывается вместе с uprobe только при --hist
+SEC("uretprobe")
+int handle_generic_uretprobe(struct pt_regs *ctx) {
+    uprobe_return(bpf_get_attach_cookie(ctx));
+    return 0;
+}
+
+SEC("uprobe")
+int handle_generic_uprobe_addr(struct pt_regs *ctx) {
+    u32 pid = bpf_get_current_pid_tgid() >> 32;
+    u64 func = PT_REGS_IP(ctx);
+    u64 key = ((u64)pid << 32) | func;
+    char *func_name = bpf_map_lookup_elem(&uprobe_configs, &key);
+    if (!func_name)
+        func_name = bpf_map_lookup_elem(&uprobe_configs, &func);   // привязка ко всем процессам
+    return uprobe_enter(ctx, 0, func, func_name);
+}
+
+SEC("uretprobe")
+int handle_generic_uretprobe_addr(struct pt_regs *ctx) {
+    uprobe_return(0);
+    return 0;
+}
+
+// =========== PROFILE ===========
+// `tracer profile`: perf_event CPU_CLOCK на каждом CPU, счётчики стеков в ядре.
+// Отдельная карта стеков без BPF_F_REUSE_STACKID — id в profile_counts
+// должны указывать на свой стек до конца записи
+struct {
+    __uint(type, BPF_MAP_TYPE_STACK_TRACE);
+    __uint(max_entries, 16384);
+    __type(key, u32);
+    __uint(value_size, MAX_STACK_DEPTH * sizeof(u64));
+} profile_stacks SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 40960);
+    __type(key, struct profile_key);
+    __type(value, u64);
+} profile_counts SEC(".maps");
+
+// Сэмплы, не посчитанные из-за переполнения profile_counts
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, u32);
+    __type(value, u64);
+} profile_dropped SEC(".maps");
+
+SEC("perf_event")
+int handle_profile(struct bpf_perf_event_data *ctx) {
+    u64 id = bpf_get_current_pid_tgid();
+    u32 pid = id >> 32;
+    // Idle-поток: CPU ничем не занят
+    if (id == 0)
+        return 0;
+    if (get_config(CONFIG_PID_FILTER) && !bpf_map_lookup_elem(&pid_filters, &pid))
+        return 0;
+    if (!cgroup_pass())
+        return 0;
+    struct profile_key key = { .pid = pid };
+    bpf_get_current_comm(&key.comm, sizeof(key.comm));
+    key.user_stack_id = bpf_get_stackid(ctx, &profile_stacks, BPF_F_USER_STACK);
+    key.kernel_stack_id = bpf_get_stackid(ctx, &profile_stacks, 0);
+
+    u64 *count = bpf_map_lookup_elem(&profile_counts, &key);
+    if (!count) {
+        u64 zero = 0;
+        bpf_map_update_elem(&profile_counts, &key, &zero, BPF_NOEXIST);
+        count = bpf_map_lookup_elem(&profile_counts, &key);
+        if (!count) {
+            u32 zero_key = 0;
+            u64 *dropped = bpf_map_lookup_elem(&profile_dropped, &zero_key);
+            if (dropped)
+                __sync_fetch_and_add(dropped, 1);
+            return 0;
+        }
+    }
+    __sync_fetch_and_add(count, 1);
     return 0;
 }
@@ -12,20 +12,272 @@
 #define EVENT_TYPE_EXIT      8
 #define EVENT_TYPE_TCP_CONN  9
 #define EVENT_TYPE_UPROBE   10
+#define EVENT_TYPE_CLOSE    11
+#define EVENT_TYPE_DATA     12
+#define EVENT_TYPE_UNLINK   13
+#define EVENT_TYPE_RENAME   14
+#define EVENT_TYPE_CHMOD    15
+#define EVENT_TYPE_CHOWN    16
+#define EVENT_TYPE_MKDIR    17
+#define EVENT_TYPE_RMDIR    18
+#define EVENT_TYPE_TRUNCATE 19
+#define EVENT_TYPE_UDP      20
+#define EVENT_TYPE_DNS      21
+#define EVENT_TYPE_SIGNAL   22
+#define EVENT_TYPE_CRED     23
+#define EVENT_TYPE_CAPABLE  24
+#define EVENT_TYPE_MODULE   25
+#define EVENT_TYPE_BPF      26
+#define EVENT_TYPE_MMAP_EXEC 27
+#define EVENT_TYPE_NAMESPACE 28
+#define EVENT_TYPE_MOUNT     29
+#define EVENT_TYPE_OOM       30
+#define EVENT_TYPE_SYSCALL   31
+#define EVENT_TYPE_BLOCK_IO  32
+
+// In event.type: a CLONE/EXECVE/EXIT that did not pass the filters, sent only
+// so userspace can keep its process tree
+#define EVENT_FLAG_HIDDEN    (1U << 31)
+
+// Indices into the config array map (runtime switches set from userspace)
+#define CONFIG_PID_FILTER       0   // only PIDs present in pid_filters are traced
+#define CONFIG_FOLLOW_CHILDREN  1   // forked children inherit the parent's pid_filters entry
+#define CONFIG_CGROUP_FILTER    2   // number of cgroups in cgroup_filters; only tasks in them or below are traced
+#define CONFIG_UID_FILTER       3   // only UIDs present in uid_filters are traced
+#define CONFIG_TID_FILTER       4   // only threads present in tid_filters are traced
+#define CONFIG_CAPTURE_BYTES    5   // read/write payload bytes to capture, 0 = off
+#define CONFIG_CAPTURE_PIDS     6   // capture only for PIDs present in capture_pids
+#define CONFIG_CAPTURE_FDS      7   // capture only for fds present in capture_fds
+#define CONFIG_COMM_FILTER      8   // FILTER_* mode for comm_filters
+#define CONFIG_PATH_FILTER      9   // FILTER_* mode for path_filters (open, execve)
+#define CONFIG_SADDR_FILTER    10   // FILTER_* mode for saddr_filters (TCP, UDP, DNS)
+#define CONFIG_DADDR_FILTER    11   // FILTER_* mode for daddr_filters (TCP, UDP, DNS)
+#define CONFIG_PORT_FILTER     12   // FILTER_* mode for port_filters (destination port)
+#define CONFIG_TCP_DIRECTION   13   // TCP_DIR_* to trace only one direction, 0 = both
+#define CONFIG_SYSCALL_FILTER  14   // FILTER_* mode for syscall_filters (SYSCALL events)
+#define CONFIG_TRACER_PID      15   // the tracer's own PID, skipped by the raw syscall programs
+#define CONFIG_SUMMARY         16   // aggregate raw syscalls into syscall_stats instead of sending events
+#define CONFIG_HIST            17   // HIST_* bits: collect syscall/uprobe latencies into latency_hists
+#define CONFIG_STACKS          18   // mask of event types (as in pid_filters) that capture stacks
+#define CONFIG_EVENT_MASK      19   // event types traced for PIDs without a pid_filters entry, 0 = all
+#define CONFIG_MAX             32
+
+// cgroup_filters slots (--cgroup paths)
+#define MAX_CGROUP_FILTERS     64
+
+// Modes of the comm/path filters
+#define FILTER_OFF        0
+#define FILTER_DENY_ONLY  1   // only "!" rules: anything not denied passes
+#define FILTER_ALLOWLIST  2   // there are allow rules: anything unmatched is dropped
+
+// Values in comm_filters/path_filters; the longest matching rule wins
+#define FILTER_ALLOW 1
+#define FILTER_DENY  2
+
+// Direction of a TCP connection or UDP datagram. Source is always the sending
+// (initiating) side, so for inbound traffic saddr/sport is the remote peer and
+// dport the local port.
+#define TCP_DIR_OUT 1
+#define TCP_DIR_IN  2
+
+// Where a SIGNAL event comes from
+#define SIGNAL_GENERATE 1   // signal_generate: sent, in the sender's context
+#define SIGNAL_DELIVER  2   // signal_deliver: dequeued by the target
+#define SIGNAL_KILL     3   // kill(2)
+#define SIGNAL_TGKILL   4   // tgkill(2)
+#define SIGNAL_TKILL    5   // tkill(2)
+
+// Which syscall a MODULE event comes from
+#define MODULE_INIT     1   // init_module(2): image from memory
+#define MODULE_FINIT    2   // finit_module(2): image from a file descriptor
+#define MODULE_DELETE   3   // delete_module(2)
+
+#define MODULE_NAME_LEN 56  // MODULE_NAME_LEN в ядре на 64-битных
+
+// Which syscall a MMAP_EXEC event comes from
+#define MMAP_OP_MMAP     1
+#define MMAP_OP_MPROTECT 2
+
+// Which syscall a NAMESPACE or MOUNT event comes from
+#define NS_OP_SETNS       1
+#define NS_OP_UNSHARE     2
+#define MOUNT_OP_MOUNT      1
+#define MOUNT_OP_UMOUNT     2
+#define MOUNT_OP_PIVOT_ROOT 3
+
+// syscall_stats: per-process, per-syscall totals for --summary
+struct syscall_stat_key {
+    u32 pid;
+    u32 nr;
+};
+struct syscall_stat {
+    u64 count;
+    u64 errors;
+    u64 total_ns;
+    u64 max_ns;
+};
+
+// CONFIG_HIST: what latency_hists is keyed by, besides the event type
+#define HIST_ENABLED  1
+#define HIST_BY_PID   2
+#define HIST_BY_NAME  4   // syscall number, uprobe attachment cookie or block device
+
+// log2 buckets of nanoseconds: slot i counts [2^i, 2^(i+1)), the last one everything above
+#define HIST_SLOTS 40
+
+// latency_hists: --hist, biolatency style. Fields not keyed by are 0.
+struct hist_key {
+    u32 type;   // EVENT_TYPE_SYSCALL, EVENT_TYPE_UPROBE or EVENT_TYPE_BLOCK_IO
+    u32 pid;
+    u64 id;     // syscall number, uprobe cookie or dev_t
+};
+struct hist {
+    u64 slots[HIST_SLOTS];
+    u64 sum_ns;
+};
+
+// LPM trie keys. An exact comm rule includes the terminating NUL in its prefix.
+struct comm_key {
+    u32 prefixlen;
+    char comm[16];
+};
+struct path_key {
+    u32 prefixlen;
+    char path[256];
+};
+// IPv4 addresses are stored IPv4-mapped (::ffff:a.b.c.d), so one trie covers both families
+struct addr_key {
+    u32 prefixlen;
+    u8 addr[16];
+};
+
+// Namespace inode numbers of a task, as in /proc/<pid>/ns/*; pid is pid_for_children
+struct ns_inums {
+    u32 mnt;
+    u32 pid;
+    u32 net;
+    u32 uts;
+    u32 ipc;
+    u32 user;
+    u32 cgroup;
+    u32 time;   // 0 before 5.6
+};
 
 struct event {
     u32 type;
-    u32 pid;
-    u32 tgid;
+    u32 pid;         // process ID (kernel TGID)
+    u32 tid;         // thread ID (kernel task pid)
+    u32 ns_pid;      // PID as seen inside the process' own pid namespace
     u64 timestamp;
-    char comm[16];
+    u64 cgroup_id;   // cgroup v2 id (inode of the cgroup directory)
+    u32 uid;
+    u32 gid;
+    char comm[16];          // process name (thread group leader)
+    char thread_comm[16];   // name of the thread that triggered the event
+    int user_stack_id;      // ids in stack_traces, negative if not captured (see CONFIG_STACKS)
+    int kernel_stack_id;
     union {
-        struct { char filename[256]; } execve;
-        struct { char filename[256]; int flags; } open;
-        struct { int fd; u64 count; } io;          // для read, write, accept, connect
-        struct { u32 saddr; u32 daddr; u16 sport; u16 dport; } tcp;
+        // old_pid != pid when a non-leader thread execs; euid/egid differing from
+        // the event's uid/gid mean a setuid/setgid binary
+        struct { char filename[256]; u32 old_pid; u32 ppid; u32 euid; u32 egid; } execve;
+        struct { u32 parent_pid; u32 child_pid; u32 child_tid; u32 thread; } clone;   // thread = 1 for a new thread
+        // exit_code/signal as in wait(2) status; runtime counts from the task's start
+        struct { u64 runtime_ns; int exit_code; u32 signal; u32 core_dumped; u32 thread; u32 ppid; } exit;   // thread = 0 only for the last thread of the group
+        struct { char filename[256]; int flags; int fd; int dfd; } open;   // fd = return value of openat, dfd = its dirfd
+        // для read, write, accept, connect, close; ret = new fd for accept.
+        // close from dup2/dup3: count = 1, ret = the fd duplicated over fd
+        struct { int fd; int ret; u64 count; } io;
+        // TCP_CONN, UDP, DNS. Addresses as in struct addr_key, ports in host byte
+        // order; len = UDP bytes sent/received. DNS carries the message as payload.
+        struct { u8 saddr[16]; u8 daddr[16]; u16 sport; u16 dport; u16 family; u16 direction; u32 len; } conn;
+        // source = SIGNAL_*; result: TRACE_SIGNAL_* for generate, syscall return for kill;
+        // code = si_code (<= 0: sent by a process, > 0: by the kernel)
+        struct {
+            u32 target_pid;   // kill: may be 0 or negative (process group)
+            u32 target_tid;
+            int sig;
+            int code;
+            int result;
+            u32 source;
+            u32 sender_pid;
+            char target_comm[16];
+        } signal;
+        // commit_creds: credentials before and after; caps = effective capability set
+        struct {
+            u32 old_uid; u32 old_euid; u32 old_gid; u32 old_egid;
+            u32 new_uid; u32 new_euid; u32 new_gid; u32 new_egid;
+            u64 old_caps;
+            u64 new_caps;
+        } cred;
+        // cap_capable: ret 0 = granted, otherwise -EPERM
+        struct { int cap; int ret; u32 opts; } capable;
+        // finit_module: путь к файлу в хвосте payload — компоненты через '\0' от файла к корню
+        struct { char name[MODULE_NAME_LEN]; u32 op; int ret; int fd; u32 flags; } module;
+        // bpf(): type — тип программы (PROG_LOAD), map (MAP_CREATE) или attach_type;
+        // name — имя программы/map или raw tracepoint
+        struct { int cmd; int ret; u32 type; u32 insn_cnt; int prog_fd; char name[64]; } bpf;
+        // mmap/mprotect с PROT_EXEC; путь к файлу, если он есть, в хвосте payload (как у module).
+        // vm_flags — флаги VMA до mprotect (VM_READ/VM_WRITE/VM_EXEC/VM_SHARED)
+        struct { u64 addr; u64 len; u32 prot; u32 flags; int fd; u32 op; u64 vm_flags; s64 ret; } mmap;
+        // setns/unshare: flags — CLONE_NEW*; target — inode файла за fd (для nsfs это namespace)
+        struct { u32 op; int ret; u64 flags; int fd; u32 target; struct ns_inums before; struct ns_inums after; } ns;
+        // mount/umount2/pivot_root: target (new_root), source (put_old) в хвосте payload
+        struct { u32 op; int ret; u64 flags; u32 mnt_ns; char fstype[32]; char target[244]; } mount;
+        // OOM kill: заголовок события — процесс, вызвавший OOM; rss и total_pages в страницах,
+        // memcg_id — id cgroup-ы при OOM в memcg (0 — глобальный), constraint — enum oom_constraint
+        struct {
+            u32 victim_pid; int oom_score_adj; char victim_comm[16];
+            u64 anon_rss; u64 file_rss; u64 shmem_rss; u64 total_pages; u64 memcg_id;
+            s64 points; u32 constraint; u32 victim_uid;
+        } oom;
+        // raw_syscalls: номер, аргументы как есть, результат и время выполнения
+        struct { u32 nr; u32 pad; u64 args[6]; s64 ret; u64 duration_ns; } syscall;
+        // block_rq_insert/issue → block_rq_complete. Заголовок — процесс, от которого пришёл
+        // запрос (может быть kworker); dev — dev_t ядра (major << 20 | minor), rwbs — операция
+        // как в blktrace ("R", "WS", "FWS", "D"); latency — от вставки в очередь, service — от выдачи
+        struct { u32 dev; u32 bytes; u64 sector; u64 latency_ns; u64 service_ns; int error; char rwbs[8]; } block;
         struct { char func[64]; u64 args[4]; } uprobe;
+        struct { int fd; u32 dir; s64 ret; u32 len; } data;  // dir: 0 = read, 1 = write; len = bytes in payload
+        // unlink, rename, chmod, chown, mkdir, rmdir, truncate; rename's new path is the payload
+        struct {
+            char path[256];
+            s64 size;        // truncate length
+            int ret;
+            int flags;
+            u32 mode;
+            u32 uid;         // (u32)-1 = unchanged
+            u32 gid;
+            int dfd;         // directory fd for *at() calls, AT_FDCWD = -100
+            int dfd2;        // rename: directory fd of the new path
+            int fd;          // ftruncate: file descriptor, path is empty
+        } fs;
     };
 };
 
+// PERF_MAX_STACK_DEPTH: frames kept per stack in stack_traces
+#define MAX_STACK_DEPTH 127
+
+// profile_counts: `tracer profile`, samples per process and stack pair.
+// Stack ids are negative when the stack was not available (-EFAULT: no user
+// stack for kernel threads, no kernel stack in user mode; -EEXIST: collision).
+struct profile_key {
+    u32 pid;
+    int user_stack_id;
+    int kernel_stack_id;
+    char comm[16];
+};
+
+// Upper bound for a variable-size tail; must be a power of two
+#define MAX_PAYLOAD 4096
+
+// Bytes of a DNS message captured (UDP port 53); enough for typical EDNS answers
+#define DNS_CAPTURE 2048
+
+// Event followed by a variable-size payload. Only sizeof(struct event) + len
+// bytes are submitted to the ring buffer.
+struct payload_event {
+    struct event ev;
+    u8 payload[MAX_PAYLOAD];
+};
+
 #endif /* __TRACER_H */
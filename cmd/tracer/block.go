@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dev_t внутри ядра: MINORBITS = 20, не то же, что у stat(2)
+const kernelMinorBits = 20
+
+func kernelDevMajor(dev uint32) uint32 { return dev >> kernelMinorBits }
+func kernelDevMinor(dev uint32) uint32 { return dev & (1<<kernelMinorBits - 1) }
+
+// blockOpName turns blktrace's rwbs ("WS", "FWFSM", "RA") into the main
+// operation; the remaining letters are flags (S sync, M metadata, A readahead,
+// F flush/FUA).
+func blockOpName(rwbs string) string {
+	switch {
+	case strings.Contains(rwbs, "D"):
+		return "discard"
+	case strings.Contains(rwbs, "E"):
+		return "secure erase"
+	case strings.Contains(rwbs, "W"):
+		return "write"
+	case strings.Contains(rwbs, "R"):
+		return "read"
+	case strings.Contains(rwbs, "F"):
+		return "flush"
+	}
+	return "none"
+}
+
+// BlockDevices names block devices by their kernel dev_t, from /sys/dev/block.
+type BlockDevices struct {
+	mu    sync.Mutex
+	names map[uint32]string
+}
+
+func NewBlockDevices() *BlockDevices {
+	return &BlockDevices{names: make(map[uint32]string)}
+}
+
+// Общий кэш: имена нужны и событиям, и гистограммам --hist
+var blockDevices = NewBlockDevices()
+
+// Name returns e.g. "nvme0n1p2", or "major:minor" if the device is unknown.
+func (b *BlockDevices) Name(dev uint32) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if name, ok := b.names[dev]; ok {
+		return name
+	}
+	id := fmt.Sprintf("%d:%d", kernelDevMajor(dev), kernelDevMinor(dev))
+	name := id
+	// /sys/dev/block/8:1 -> ../../block/sda/sda1
+	if target, err := os.Readlink("/sys/dev/block/" + id); err == nil {
+		name = filepath.Base(target)
+	}
+	b.names[dev] = name
+	return name
+}
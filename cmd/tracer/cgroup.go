@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Не пересканируем дерево cgroup чаще, чем раз в этот интервал
+const cgroupRescanInterval = 2 * time.Second
+
+// Тип файлового дескриптора kernfs (FILEID_KERNFS): внутри 8 байт id cgroup
+const fileIDKernfs = 0xfe
+
+// Последний элемент пути cgroup у контейнерных рантаймов:
+//
+//	docker:     /system.slice/docker-<id>.scope, /docker/<id>
+//	containerd: /kubepods.slice/.../cri-containerd-<id>.scope, /kubepods/.../<id>
+//	cri-o:      /kubepods.slice/.../crio-<id>.scope
+var containerIDPattern = regexp.MustCompile(`^(?:docker-|cri-containerd-|crio-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// CgroupResolver maps cgroup v2 ids to paths and container ids.
+type CgroupResolver struct {
+	mountFD int // cgroup2 для open_by_handle_at; -1, если не открылся
+
+	mu         sync.Mutex
+	paths      map[uint64]string
+	lastRescan time.Time
+}
+
+func NewCgroupResolver() *CgroupResolver {
+	r := &CgroupResolver{paths: scanCgroups(), lastRescan: time.Now(), mountFD: -1}
+	if fd, err := unix.Open(cgroupRoot, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0); err == nil {
+		r.mountFD = fd
+	}
+	return r
+}
+
+// Resolve returns the cgroup path (relative to the cgroup2 mount) and the
+// container id, if the path belongs to a known container runtime.
+func (r *CgroupResolver) Resolve(id uint64) (path, containerID string) {
+	if id == 0 {
+		return "", ""
+	}
+	r.mu.Lock()
+	path, ok := r.paths[id]
+	r.mu.Unlock()
+	if !ok {
+		path = r.lookup(id)
+	}
+	return path, containerIDFromPath(path)
+}
+
+// lookup resolves an id missing from the cache without holding the lock: by
+// its kernfs file handle, one open_by_handle_at and a readlink. Where file
+// handles are not available it rescans the hierarchy, at most once per
+// cgroupRescanInterval.
+func (r *CgroupResolver) lookup(id uint64) string {
+	path, err := cgroupPathByID(r.mountFD, id)
+	switch {
+	case err == nil:
+	case errors.Is(err, unix.ESTALE), errors.Is(err, unix.ENOENT):
+		// cgroup уже удалён — пересканирование его не найдёт
+		return ""
+	default:
+		r.mu.Lock()
+		due := time.Since(r.lastRescan) > cgroupRescanInterval
+		if due {
+			r.lastRescan = time.Now()
+		}
+		r.mu.Unlock()
+		if !due {
+			return ""
+		}
+		paths := scanCgroups()
+		r.mu.Lock()
+		for k, v := range paths {
+			r.paths[k] = v
+		}
+		path = r.paths[id]
+		r.mu.Unlock()
+		return path
+	}
+	r.mu.Lock()
+	r.paths[id] = path
+	r.mu.Unlock()
+	return path
+}
+
+// cgroupPathByID opens the cgroup by its id through the cgroup2 mount and
+// reads the path back from /proc/self/fd. Needs CAP_DAC_READ_SEARCH.
+func cgroupPathByID(mountFD int, id uint64) (string, error) {
+	if mountFD < 0 {
+		return "", unix.EBADF
+	}
+	handle := make([]byte, 8)
+	binary.NativeEndian.PutUint64(handle, id)
+	fd, err := unix.OpenByHandleAt(mountFD, unix.NewFileHandle(fileIDKernfs, handle), unix.O_PATH|unix.O_CLOEXEC)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(fd)
+	p, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err != nil {
+		return "", err
+	}
+	return cgroupRelPath(p), nil
+}
+
+func cgroupRelPath(p string) string {
+	return "/" + strings.TrimPrefix(strings.TrimPrefix(p, cgroupRoot), "/")
+}
+
+// scanCgroups walks the cgroup2 hierarchy; the cgroup id is the directory inode.
+func scanCgroups() map[uint64]string {
+	paths := make(map[uint64]string)
+	filepath.WalkDir(cgroupRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if id, err := cgroupID(p); err == nil {
+			paths[id] = cgroupRelPath(p)
+		}
+		return nil
+	})
+	return paths
+}
+
+func containerIDFromPath(path string) string {
+	m := containerIDPattern.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func cgroupID(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+// Слотов в cgroup_filters, MAX_CGROUP_FILTERS в tracer.h
+const maxCgroupFilters = 64
+
+// fillCgroupFilter puts the --cgroup paths into the cgroup_filters array and
+// returns how many there are. The kernel checks that a task is in one of
+// them or below, so cgroups created later are covered as well.
+func fillCgroupFilter(m *ebpf.Map, paths []string) (int, error) {
+	n := 0
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if n == maxCgroupFilters {
+			return 0, fmt.Errorf("at most %d cgroups can be filtered", maxCgroupFilters)
+		}
+		if !strings.HasPrefix(p, cgroupRoot) {
+			p = filepath.Join(cgroupRoot, p)
+		}
+		// Ядро берёт ссылку на cgroup при записи, fd можно сразу закрыть
+		f, err := os.Open(p)
+		if err != nil {
+			return 0, fmt.Errorf("cgroup %s: %w", p, err)
+		}
+		err = m.Put(uint32(n), uint32(f.Fd()))
+		f.Close()
+		if err != nil {
+			return 0, fmt.Errorf("set cgroup filter %s: %w", p, err)
+		}
+		n++
+	}
+	return n, nil
+}
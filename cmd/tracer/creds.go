@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// CAP_OPT_* из include/linux/security.h
+const (
+	CAP_OPT_NOAUDIT = 0x2
+	CAP_OPT_INSETID = 0x4
+)
+
+// Имена capabilities по номеру, include/uapi/linux/capability.h
+var capabilityNames = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+func capabilityName(capability int32) string {
+	if capability >= 0 && int(capability) < len(capabilityNames) {
+		return capabilityNames[capability]
+	}
+	return fmt.Sprintf("CAP_%d", capability)
+}
+
+// capabilityList names the bits set in a capability mask, lowest first.
+func capabilityList(mask uint64) []string {
+	var out []string
+	for mask != 0 {
+		bit := bits.TrailingZeros64(mask)
+		out = append(out, capabilityName(int32(bit)))
+		mask &^= 1 << bit
+	}
+	return out
+}
+
+// describeCaps renders a capability mask compactly: "full" for every known
+// capability, "none", or the list of names.
+func describeCaps(mask uint64) string {
+	full := uint64(1)<<len(capabilityNames) - 1
+	switch {
+	case mask == 0:
+		return "none"
+	case mask&full == full:
+		return "full"
+	}
+	return strings.Join(capabilityList(mask), ",")
+}
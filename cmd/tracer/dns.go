@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Ответ держим не меньше этого, даже при TTL 0: соединение открывают сразу
+// после резолва, но событие может прийти чуть позже
+const dnsCacheMinTTL = 5 * time.Second
+
+// Верхняя граница кэша; при переполнении выбрасываем просроченные, затем всё
+const dnsCacheMaxEntries = 65536
+
+// Как часто Add вычищает просроченные записи
+const dnsCacheSweepInterval = time.Minute
+
+// DNSInfo is a decoded DNS query or response.
+type DNSInfo struct {
+	ID       uint16
+	Response bool
+	Name     string // имя из секции вопроса, без точки в конце
+	Type     string // A, AAAA, ...
+	RCode    string // только для ответов
+	Answers  []string
+	addrs    []netip.Addr
+	ttl      time.Duration
+}
+
+// parseDNS decodes the question and the answer records of a DNS message.
+// A message cut short by the capture limit still yields what was parsed.
+func parseDNS(msg []byte) (*DNSInfo, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	info := &DNSInfo{ID: h.ID, Response: h.Response}
+	if h.Response {
+		info.RCode = strings.TrimPrefix(h.RCode.String(), "RCode")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	info.Name = strings.TrimSuffix(q.Name.String(), ".")
+	info.Type = strings.TrimPrefix(q.Type.String(), "Type")
+	if !h.Response {
+		return info, nil
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return info, nil
+	}
+	for {
+		rh, err := p.AnswerHeader()
+		if err != nil {
+			break
+		}
+		ttl := time.Duration(rh.TTL) * time.Second
+		if info.ttl == 0 || ttl < info.ttl {
+			info.ttl = ttl
+		}
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return info, nil
+			}
+			addr := netip.AddrFrom4(r.A)
+			info.addrs = append(info.addrs, addr)
+			info.Answers = append(info.Answers, addr.String())
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return info, nil
+			}
+			addr := netip.AddrFrom16(r.AAAA)
+			info.addrs = append(info.addrs, addr)
+			info.Answers = append(info.Answers, addr.String())
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return info, nil
+			}
+			info.Answers = append(info.Answers, "CNAME "+strings.TrimSuffix(r.CNAME.String(), "."))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return info, nil
+			}
+		}
+	}
+	return info, nil
+}
+
+type dnsCacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// DNSCache remembers which name resolved to which address for each process,
+// so connections it makes afterwards can be shown with the host name it asked
+// for. Another process connecting to the same address, which may be a shared
+// CDN or load balancer, does not get that name.
+type DNSCache struct {
+	mu        sync.Mutex
+	procs     map[uint32]map[netip.Addr]dnsCacheEntry // по PID процесса, получившего ответ
+	size      int
+	lastSweep time.Time
+}
+
+func NewDNSCache() *DNSCache {
+	return &DNSCache{procs: make(map[uint32]map[netip.Addr]dnsCacheEntry)}
+}
+
+// Add records the addresses of a DNS response pid received under the queried
+// name, for the TTL of the answer.
+func (c *DNSCache) Add(pid uint32, info *DNSInfo, now time.Time) {
+	if len(info.addrs) == 0 {
+		return
+	}
+	ttl := info.ttl
+	if ttl < dnsCacheMinTTL {
+		ttl = dnsCacheMinTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > dnsCacheSweepInterval || c.size+len(info.addrs) > dnsCacheMaxEntries {
+		c.sweep(now)
+		if c.size+len(info.addrs) > dnsCacheMaxEntries {
+			c.procs = make(map[uint32]map[netip.Addr]dnsCacheEntry)
+			c.size = 0
+		}
+	}
+	entries := c.procs[pid]
+	if entries == nil {
+		entries = make(map[netip.Addr]dnsCacheEntry)
+		c.procs[pid] = entries
+	}
+	for _, addr := range info.addrs {
+		if _, ok := entries[addr]; !ok {
+			c.size++
+		}
+		entries[addr] = dnsCacheEntry{name: info.Name, expires: now.Add(ttl)}
+	}
+}
+
+// sweep drops expired entries. Must be called with c.mu held.
+func (c *DNSCache) sweep(now time.Time) {
+	c.lastSweep = now
+	for pid, entries := range c.procs {
+		for addr, e := range entries {
+			if now.After(e.expires) {
+				delete(entries, addr)
+				c.size--
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.procs, pid)
+		}
+	}
+}
+
+// Lookup returns the name addr was last resolved from by pid, or "".
+func (c *DNSCache) Lookup(pid uint32, addr netip.Addr, now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.procs[pid][addr.Unmap()]
+	if !ok || now.After(e.expires) {
+		return ""
+	}
+	return e.name
+}
+
+// Forget drops the answers of an exited process.
+func (c *DNSCache) Forget(pid uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size -= len(c.procs[pid])
+	delete(c.procs, pid)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func buildDNS(t *testing.T, h dnsmessage.Header, name string, answers func(b *dnsmessage.Builder)) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, h)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	if err := b.Question(q); err != nil {
+		t.Fatal(err)
+	}
+	if answers != nil {
+		if err := b.StartAnswers(); err != nil {
+			t.Fatal(err)
+		}
+		answers(&b)
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func answerHeader(name string, typ dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	msg := buildDNS(t, dnsmessage.Header{ID: 42}, "example.com.", nil)
+	info, err := parseDNS(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != 42 || info.Response || info.Name != "example.com" || info.Type != "A" || info.RCode != "" {
+		t.Errorf("parseDNS = %+v", info)
+	}
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	msg := buildDNS(t, dnsmessage.Header{ID: 7, Response: true}, "www.example.com.", func(b *dnsmessage.Builder) {
+		b.CNAMEResource(answerHeader("www.example.com.", dnsmessage.TypeCNAME, 300),
+			dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("example.com.")})
+		b.AResource(answerHeader("example.com.", dnsmessage.TypeA, 60),
+			dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}})
+		b.AAAAResource(answerHeader("example.com.", dnsmessage.TypeAAAA, 120),
+			dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("2606:2800:220:1::1").As16()})
+	})
+	info, err := parseDNS(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Response || info.Name != "www.example.com" || info.RCode != "Success" {
+		t.Errorf("parseDNS = %+v", info)
+	}
+	want := []string{"CNAME example.com", "93.184.216.34", "2606:2800:220:1::1"}
+	if !reflect.DeepEqual(info.Answers, want) {
+		t.Errorf("Answers = %q, want %q", info.Answers, want)
+	}
+	if len(info.addrs) != 2 {
+		t.Errorf("addrs = %v, want 2 addresses", info.addrs)
+	}
+	// Кэшируем по самому короткому TTL среди записей
+	if info.ttl != time.Minute {
+		t.Errorf("ttl = %v, want 1m", info.ttl)
+	}
+}
+
+func TestParseDNSTruncated(t *testing.T) {
+	msg := buildDNS(t, dnsmessage.Header{Response: true}, "example.com.", func(b *dnsmessage.Builder) {
+		b.AResource(answerHeader("example.com.", dnsmessage.TypeA, 60), dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}})
+		b.AResource(answerHeader("example.com.", dnsmessage.TypeA, 60), dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}})
+	})
+	// Обрезаем посреди второй записи, как при лимите захвата
+	info, err := parseDNS(msg[:len(msg)-2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1"}; !reflect.DeepEqual(info.Answers, want) {
+		t.Errorf("Answers = %q, want %q", info.Answers, want)
+	}
+
+	if _, err := parseDNS(msg[:5]); err == nil {
+		t.Error("parseDNS of a cut header succeeded")
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	const pid, other = 100, 200
+	now := time.Now()
+	addr := netip.MustParseAddr("10.0.0.1")
+	c := NewDNSCache()
+	c.Add(pid, &DNSInfo{Name: "example.com", addrs: []netip.Addr{addr}, ttl: 2 * time.Minute}, now)
+
+	if got := c.Lookup(pid, addr, now); got != "example.com" {
+		t.Errorf("Lookup = %q, want example.com", got)
+	}
+	// Адреса соединений приходят IPv4-mapped
+	if got := c.Lookup(pid, netip.AddrFrom16(addr.As16()), now); got != "example.com" {
+		t.Errorf("Lookup of the mapped address = %q, want example.com", got)
+	}
+	// Запись живёт TTL ответа
+	if got := c.Lookup(pid, addr, now.Add(2*time.Minute-time.Second)); got != "example.com" {
+		t.Errorf("Lookup before the TTL = %q, want example.com", got)
+	}
+	if got := c.Lookup(pid, addr, now.Add(2*time.Minute+time.Second)); got != "" {
+		t.Errorf("Lookup after the TTL = %q, want none", got)
+	}
+	if got := c.Lookup(pid, netip.MustParseAddr("10.0.0.2"), now); got != "" {
+		t.Errorf("Lookup of an unknown address = %q, want none", got)
+	}
+	// Ответ одного процесса не подписывает соединения другого
+	if got := c.Lookup(other, addr, now); got != "" {
+		t.Errorf("Lookup by another process = %q, want none", got)
+	}
+	c.Add(other, &DNSInfo{Name: "cdn.example", addrs: []netip.Addr{addr}, ttl: time.Minute}, now)
+	if got := c.Lookup(pid, addr, now); got != "example.com" {
+		t.Errorf("Lookup after another process' answer = %q, want example.com", got)
+	}
+
+	// Ответ без адресов ничего не затирает
+	c.Add(pid, &DNSInfo{Name: "other.example"}, now)
+	if got := c.Lookup(pid, addr, now); got != "example.com" {
+		t.Errorf("Lookup after an empty answer = %q, want example.com", got)
+	}
+
+	// Выход процесса забывает его ответы
+	c.Forget(pid)
+	if got := c.Lookup(pid, addr, now); got != "" {
+		t.Errorf("Lookup after exit = %q, want none", got)
+	}
+	if c.size != 1 {
+		t.Errorf("size = %d after exit, want 1", c.size)
+	}
+}
+
+func TestDNSCacheMinTTL(t *testing.T) {
+	now := time.Now()
+	addr := netip.MustParseAddr("10.0.0.1")
+	c := NewDNSCache()
+	// TTL 0 продлевается до dnsCacheMinTTL
+	c.Add(1, &DNSInfo{Name: "example.com", addrs: []netip.Addr{addr}}, now)
+	if got := c.Lookup(1, addr, now.Add(dnsCacheMinTTL-time.Second)); got != "example.com" {
+		t.Errorf("Lookup before the minimum TTL = %q, want example.com", got)
+	}
+	if got := c.Lookup(1, addr, now.Add(dnsCacheMinTTL+time.Second)); got != "" {
+		t.Errorf("Lookup after the minimum TTL = %q, want none", got)
+	}
+
+	// Просроченное вычищается при следующем Add
+	c.Add(2, &DNSInfo{Name: "other.example", addrs: []netip.Addr{addr}}, now.Add(dnsCacheSweepInterval+time.Second))
+	if _, ok := c.procs[1]; ok || c.size != 1 {
+		t.Errorf("after the sweep: pid 1 kept = %v, size = %d, want false and 1", ok, c.size)
+	}
+}
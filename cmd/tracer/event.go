@@ -1,21 +1,235 @@
 package main
-import "time"
+import (
+    "net/netip"
+    "time"
+)
 
 
 // Это минимальный набор для пайплайна ringbuf → processor
 type EventRaw struct {
-    Type      uint32
-    PID       uint32
-    Tgid      uint32
-    Timestamp uint64
-    Comm      [16]byte
-    Data      [264]byte // строго под union в C
+    Type       uint32
+    PID        uint32 // process ID (TGID)
+    TID        uint32 // thread ID
+    NsPID      uint32
+    Timestamp  uint64
+    CgroupID   uint64
+    UID        uint32
+    GID        uint32
+    Comm       [16]byte
+    ThreadComm [16]byte
+    UserStackID   int32 // id в stack_traces, < 0 — стек не снимали
+    KernelStackID int32
+    Data       [296]byte // строго под union в C
+    Payload    []byte    // хвост payload_event (захваченные байты), если есть
+    Hidden     bool      // не прошло фильтры, пришло только для дерева процессов
 }
 
 type ProcessedEvent struct {
-    Type      string
-    PID       uint32
-    Comm      string
-    Timestamp time.Time
-    Details   string
+    Type        string
+    PID         uint32
+    TID         uint32
+    NsPID       uint32
+    Comm        string
+    ThreadName  string
+    Timestamp   time.Time
+    Details     string
+    CgroupID    uint64
+    CgroupPath  string
+    ContainerID string
+    FDTarget    string // файл, pipe или сокет, на который указывает fd события
+    Data        []byte // захваченное содержимое read/write
+    UID         uint32
+    GID         uint32
+    User        string
+    Ancestry    string // цепочка родителей: "bash(100) > sshd(99) > systemd(1)"
+    FileOp      *FileOpInfo // для UNLINK, RENAME, CHMOD, CHOWN, MKDIR, RMDIR, TRUNCATE
+    Process     *ProcessInfo // для CLONE, EXECVE, EXIT
+    Connection  *ConnectionInfo // для TCP_CONN, UDP, DNS
+    Signal      *SignalInfo     // для SIGNAL
+    Cred        *CredInfo       // для CRED
+    Capability  *CapabilityInfo // для CAPABLE
+    Module      *ModuleInfo     // для MODULE
+    BPF         *BPFInfo        // для BPF
+    MemoryMap   *MemoryMapInfo  // для MMAP_EXEC
+    Namespace   *NamespaceInfo  // для NAMESPACE
+    Mount       *MountInfo      // для MOUNT
+    OOM         *OOMInfo        // для OOM
+    Syscall     *SyscallInfo    // для SYSCALL
+    BlockIO     *BlockIOInfo    // для BLOCK_IO
+    UserStack   []StackFrame    // --stacks: от вершины стека вниз
+    KernelStack []StackFrame
+}
+
+// Запрос к блочному устройству; PID/Comm события — процесс, от которого он пришёл
+type BlockIOInfo struct {
+    Major   uint32
+    Minor   uint32
+    Device  string // имя из /sys/dev/block, например nvme0n1p2
+    Op      string // read, write, flush, discard, ...
+    RWBS    string // как в blktrace: операция и флаги
+    Sector  uint64
+    Bytes   uint32
+    Latency time.Duration // от вставки в очередь (или выдачи драйверу) до завершения
+    Service time.Duration // от выдачи драйверу до завершения
+    Error   int32
+}
+
+// Произвольный syscall (raw_syscalls, --syscalls)
+type SyscallInfo struct {
+    Nr       uint32
+    Name     string
+    Args     [6]uint64
+    Ret      int64
+    Duration time.Duration
+}
+
+// Убийство OOM killer-ом; PID/Comm события — процесс, вызвавший OOM
+type OOMInfo struct {
+    VictimPID   uint32
+    VictimComm  string
+    VictimUID   uint32
+    OOMScoreAdj int32
+    AnonRSS     uint64 // байт, на момент убийства
+    FileRSS     uint64
+    ShmemRSS    uint64
+    TotalMemory uint64 // сколько памяти было доступно: лимит memcg или вся память
+    MemcgID     uint64 // 0 — глобальный OOM
+    MemcgPath   string
+    Points      int64  // badness жертвы
+    Constraint  string // none, cpuset, memory policy, memcg
+}
+
+// Переход между namespace-ами (setns, unshare)
+type NamespaceInfo struct {
+    Op      string   // setns, unshare
+    Flags   []string // CLONE_NEW*
+    FD      int32    // setns
+    Target  uint32   // setns: inode файла за fd — для /proc/<pid>/ns/* это namespace
+    Result  int32
+    Before  NamespaceSet
+    After   NamespaceSet
+    Changed []string // "net 4026531840→4026532301"
+}
+
+// mount, umount2, pivot_root
+type MountInfo struct {
+    Op      string // mount, umount2, pivot_root
+    Source  string // pivot_root: put_old
+    Target  string // pivot_root: new_root
+    FSType  string
+    Flags   []string // MS_* или MNT_*
+    MountNS uint32
+    Result  int32
+}
+
+// mmap/mprotect, запросившие PROT_EXEC
+type MemoryMapInfo struct {
+    Op           string // mmap, mprotect
+    Addr         uint64
+    Length       uint64
+    Prot         uint32 // PROT_*
+    Flags        uint32 // mmap: MAP_*
+    FD           int32
+    File         string // файл за регионом; пусто — анонимная память или неизвестно
+    PrevProt     uint32 // mprotect: права региона до вызова, если известны
+    Result       int64
+    Anonymous    bool
+    WriteExec    bool // запрошены W и X одновременно
+    WXTransition bool // mprotect: регион был записываемым, стал исполняемым
+}
+
+// Загрузка или выгрузка модуля ядра
+type ModuleInfo struct {
+    Op     string // init_module, finit_module, delete_module
+    Name   string // пусто, если загрузка сорвалась до разбора модуля
+    Path   string // finit_module: файл модуля
+    FD     int32
+    Flags  uint32
+    Result int32
+}
+
+// Загрузка или подключение BPF-объекта через bpf()
+type BPFInfo struct {
+    Command  string // BPF_PROG_LOAD, BPF_MAP_CREATE, ...
+    Type     string // тип программы или map, attach type
+    Name     string
+    InsnCnt  uint32 // PROG_LOAD
+    ProgFD   int32  // PROG_ATTACH, LINK_CREATE, RAW_TRACEPOINT_OPEN
+    Result   int32  // fd нового объекта или -errno
+}
+
+// Смена credentials (commit_creds): id и эффективные capabilities до и после
+type CredInfo struct {
+    OldUID, OldEUID, OldGID, OldEGID uint32
+    NewUID, NewEUID, NewGID, NewEGID uint32
+    OldCaps    uint64
+    NewCaps    uint64
+    Gained     []string // capabilities, появившиеся в effective-наборе
+    Lost       []string
+}
+
+// Проверка capability (cap_capable); показывается первая для процесса
+type CapabilityInfo struct {
+    Cap     int32
+    Name    string
+    Allowed bool
+    Opts    uint32 // CAP_OPT_*
+}
+
+// Отправка или доставка сигнала
+type SignalInfo struct {
+    Source     string // generate, deliver, kill, tgkill, tkill
+    Signal     int32
+    Code       int32 // si_code: <= 0 — от процесса, > 0 — от ядра
+    Result     int32 // generate: TRACE_SIGNAL_*; kill: результат syscall
+    SenderPID  uint32
+    SenderComm string
+    TargetPID  uint32 // kill: может быть 0 или отрицательным (группа процессов)
+    TargetTID  uint32
+    TargetComm string
+}
+
+// Сетевое соединение; источник — инициатор, т.е. для входящих это удалённая сторона
+type ConnectionInfo struct {
+    Protocol  string
+    SrcIP     netip.Addr
+    SrcPort   uint16
+    DstIP     netip.Addr
+    DstPort   uint16
+    Direction uint16 // TCP_DIR_OUT или TCP_DIR_IN
+    Length    uint32 // UDP: байт в датаграмме
+    Hostname  string // имя, из которого был получен удалённый адрес (по DNS-ответам)
+    DNS       *DNSInfo
+}
+
+// Жизненный цикл процесса: родство для CLONE/EXECVE, статус для EXIT
+type ProcessInfo struct {
+    ParentPID  uint32
+    ChildPID   uint32 // CLONE
+    ChildTID   uint32 // CLONE
+    OldPID     uint32 // EXECVE: TID потока, вызвавшего exec
+    EUID       uint32 // EXECVE: эффективные id после exec
+    EGID       uint32
+    Setuid     bool   // EXECVE: euid != uid — setuid-бинарь
+    Setgid     bool
+    Thread     bool   // CLONE потока; EXIT не последнего потока группы
+    ExitCode   int32
+    Signal     uint32 // сигнал, убивший процесс; 0 — обычный выход
+    CoreDumped bool
+    Runtime    time.Duration
+    KillerPID  uint32 // EXIT от сигнала: кто его послал, если видели
+    KillerComm string
+    OOMKilled  bool   // EXIT: убит OOM killer-ом, Killer* — процесс, вызвавший OOM
+}
+
+// Разобранные аргументы файловой операции
+type FileOpInfo struct {
+    Path    string
+    NewPath string // только для RENAME
+    Mode    uint32
+    UID     int32 // -1 = не меняется
+    GID     int32
+    Size    int64
+    Flags   int32
+    Result  int32
 }
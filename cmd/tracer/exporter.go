@@ -4,17 +4,27 @@ import (
 )
 
 import (
+	"context"
 	"log"
 	"net"
+	"strings"
 
 	pb "ebpf-tracer/proto" // Импорт из твоего go_package
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type Exporter struct {
 	pb.UnimplementedTracerServiceServer
-	out chan *ProcessedEvent
+	out    chan *ProcessedEvent
+	tree    *ProcessTree
+	loader  *Loader
+	uprobes *UprobeManager
+
+	remoteFilters bool // --allow-remote-filters
 }
 func sanitizeString(s string) string {
     if !utf8.ValidString(s) {
@@ -23,11 +33,33 @@ func sanitizeString(s string) string {
     return s
 }
 
-func NewExporter(out chan *ProcessedEvent) *Exporter {
-	return &Exporter{out: out}
+func stackToProto(frames []StackFrame) []*pb.StackFrame {
+	if len(frames) == 0 {
+		return nil
+	}
+	out := make([]*pb.StackFrame, len(frames))
+	for i, f := range frames {
+		out[i] = &pb.StackFrame{
+			Address:  f.Address,
+			Function: sanitizeString(f.Function),
+			Offset:   f.Offset,
+			Module:   sanitizeString(f.Module),
+			File:     sanitizeString(f.File),
+			Line:     uint32(f.Line),
+		}
+	}
+	return out
+}
+
+func NewExporter(out chan *ProcessedEvent, tree *ProcessTree, loader *Loader, uprobes *UprobeManager) *Exporter {
+	return &Exporter{out: out, tree: tree, loader: loader, uprobes: uprobes}
 }
 
 func (e *Exporter) StreamEvents(req *pb.EventRequest, stream pb.TracerService_StreamEventsServer) error {
+	netMatch, err := NewNetMatcher(req.SrcCidrs, req.DstCidrs, req.Ports, req.Direction)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
 	for event := range e.out {
 		// фильтрация по PID
 		if len(req.Pids) > 0 && !containsUint32(req.Pids, event.PID) {
@@ -37,13 +69,237 @@ func (e *Exporter) StreamEvents(req *pb.EventRequest, stream pb.TracerService_St
 		if len(req.Types) > 0 && !containsString(req.Types, event.Type) {
 			continue
 		}
+		// фильтрация по потоку
+		if len(req.Tids) > 0 && !containsUint32(req.Tids, event.TID) {
+			continue
+		}
+		// фильтрация по пользователю
+		if len(req.Uids) > 0 && !containsUint32(req.Uids, event.UID) {
+			continue
+		}
+		// фильтрация по контейнеру (допускается короткий ID)
+		if len(req.ContainerIds) > 0 && !containsPrefix(req.ContainerIds, event.ContainerID) {
+			continue
+		}
+		// сетевые фильтры (только для событий с соединением)
+		if !netMatch.Match(event.Connection) {
+			continue
+		}
 
 		resp := &pb.Event{
-			Type:      event.Type,
-			Pid:       event.PID,
-			Comm:      sanitizeString(event.Comm),
-			Timestamp: timestamppb.New(event.Timestamp),
-			Details:   sanitizeString(event.Details),
+			Type:        event.Type,
+			Pid:         event.PID,
+			Comm:        sanitizeString(event.Comm),
+			Timestamp:   timestamppb.New(event.Timestamp),
+			Details:     sanitizeString(event.Details),
+			NsPid:       event.NsPID,
+			CgroupId:    event.CgroupID,
+			CgroupPath:  sanitizeString(event.CgroupPath),
+			ContainerId: event.ContainerID,
+			Uid:         event.UID,
+			Gid:         event.GID,
+			User:        sanitizeString(event.User),
+			Tid:         event.TID,
+			ThreadName:  sanitizeString(event.ThreadName),
+			FdTarget:    sanitizeString(event.FDTarget),
+			Data:        event.Data,
+			Ancestry:    sanitizeString(event.Ancestry),
+			UserStack:   stackToProto(event.UserStack),
+			KernelStack: stackToProto(event.KernelStack),
+		}
+		if op := event.FileOp; op != nil {
+			resp.Payload = &pb.Event_FileOp{FileOp: &pb.FileOp{
+				Path:    sanitizeString(op.Path),
+				NewPath: sanitizeString(op.NewPath),
+				Mode:    op.Mode,
+				Uid:     op.UID,
+				Gid:     op.GID,
+				Size:    op.Size,
+				Flags:   op.Flags,
+				Result:  op.Result,
+			}}
+		}
+		if c := event.Connection; c != nil {
+			conn := &pb.Connection{
+				Protocol:  c.Protocol,
+				SrcIp:     c.SrcIP.String(),
+				SrcPort:   uint32(c.SrcPort),
+				DstIp:     c.DstIP.String(),
+				DstPort:   uint32(c.DstPort),
+				Direction: directionName(c.Direction),
+				Length:    c.Length,
+				Hostname:  sanitizeString(c.Hostname),
+			}
+			if d := c.DNS; d != nil {
+				conn.Dns = &pb.Dns{
+					Id:       uint32(d.ID),
+					Response: d.Response,
+					Name:     sanitizeString(d.Name),
+					Type:     d.Type,
+					Rcode:    d.RCode,
+					Answers:  d.Answers,
+				}
+			}
+			resp.Payload = &pb.Event_Connection{Connection: conn}
+		}
+		if info := event.Process; info != nil {
+			resp.Payload = &pb.Event_Process{Process: &pb.Process{
+				ParentPid:  info.ParentPID,
+				ChildPid:   info.ChildPID,
+				ChildTid:   info.ChildTID,
+				OldPid:     info.OldPID,
+				Thread:     info.Thread,
+				ExitCode:   info.ExitCode,
+				Signal:     info.Signal,
+				CoreDumped: info.CoreDumped,
+				RuntimeNs:  uint64(info.Runtime),
+				KillerPid:  info.KillerPID,
+				KillerComm: sanitizeString(info.KillerComm),
+				Euid:       info.EUID,
+				Egid:       info.EGID,
+				Setuid:     info.Setuid,
+				Setgid:     info.Setgid,
+				OomKilled:  info.OOMKilled,
+			}}
+		}
+		if c := event.Cred; c != nil {
+			resp.Payload = &pb.Event_Cred{Cred: &pb.Credentials{
+				OldUid:     c.OldUID,
+				OldEuid:    c.OldEUID,
+				OldGid:     c.OldGID,
+				OldEgid:    c.OldEGID,
+				NewUid:     c.NewUID,
+				NewEuid:    c.NewEUID,
+				NewGid:     c.NewGID,
+				NewEgid:    c.NewEGID,
+				OldCaps:    c.OldCaps,
+				NewCaps:    c.NewCaps,
+				CapsGained: c.Gained,
+				CapsLost:   c.Lost,
+			}}
+		}
+		if m := event.Module; m != nil {
+			resp.Payload = &pb.Event_Module{Module: &pb.Module{
+				Op:     m.Op,
+				Name:   m.Name,
+				Path:   m.Path,
+				Fd:     m.FD,
+				Flags:  m.Flags,
+				Result: m.Result,
+			}}
+		}
+		if b := event.BPF; b != nil {
+			resp.Payload = &pb.Event_Bpf{Bpf: &pb.BpfLoad{
+				Command: b.Command,
+				Type:    b.Type,
+				Name:    b.Name,
+				InsnCnt: b.InsnCnt,
+				ProgFd:  b.ProgFD,
+				Result:  b.Result,
+			}}
+		}
+		if m := event.MemoryMap; m != nil {
+			mm := &pb.MemoryMap{
+				Op:           m.Op,
+				Addr:         m.Addr,
+				Length:       m.Length,
+				Prot:         protString(m.Prot),
+				File:         m.File,
+				Result:       m.Result,
+				Anonymous:    m.Anonymous,
+				WriteExec:    m.WriteExec,
+				WxTransition: m.WXTransition,
+			}
+			if m.Op == "mmap" {
+				mm.Flags = mmapFlagsString(m.Flags)
+			} else if m.PrevProt != 0 {
+				mm.PrevProt = protString(m.PrevProt)
+			}
+			resp.Payload = &pb.Event_MemoryMap{MemoryMap: mm}
+		}
+		if n := event.Namespace; n != nil {
+			resp.Payload = &pb.Event_Namespace{Namespace: &pb.Namespace{
+				Op:      n.Op,
+				Flags:   n.Flags,
+				Fd:      n.FD,
+				Target:  n.Target,
+				Result:  n.Result,
+				Before:  namespacesToProto(n.Before),
+				After:   namespacesToProto(n.After),
+				Changed: n.Changed,
+			}}
+		}
+		if m := event.Mount; m != nil {
+			resp.Payload = &pb.Event_Mount{Mount: &pb.Mount{
+				Op:      m.Op,
+				Source:  m.Source,
+				Target:  m.Target,
+				FsType:  m.FSType,
+				Flags:   m.Flags,
+				MountNs: m.MountNS,
+				Result:  m.Result,
+			}}
+		}
+		if o := event.OOM; o != nil {
+			resp.Payload = &pb.Event_Oom{Oom: &pb.OomKill{
+				VictimPid:   o.VictimPID,
+				VictimComm:  sanitizeString(o.VictimComm),
+				VictimUid:   o.VictimUID,
+				OomScoreAdj: o.OOMScoreAdj,
+				AnonRss:     o.AnonRSS,
+				FileRss:     o.FileRSS,
+				ShmemRss:    o.ShmemRSS,
+				TotalMemory: o.TotalMemory,
+				MemcgId:     o.MemcgID,
+				MemcgPath:   o.MemcgPath,
+				Points:      o.Points,
+				Constraint:  o.Constraint,
+			}}
+		}
+		if s := event.Syscall; s != nil {
+			resp.Payload = &pb.Event_Syscall{Syscall: &pb.Syscall{
+				Nr:         s.Nr,
+				Name:       s.Name,
+				Args:       s.Args[:],
+				Ret:        s.Ret,
+				DurationNs: uint64(s.Duration),
+			}}
+		}
+		if b := event.BlockIO; b != nil {
+			resp.Payload = &pb.Event_BlockIo{BlockIo: &pb.BlockIo{
+				Major:     b.Major,
+				Minor:     b.Minor,
+				Device:    b.Device,
+				Op:        b.Op,
+				Rwbs:      b.RWBS,
+				Sector:    b.Sector,
+				Bytes:     b.Bytes,
+				LatencyNs: uint64(b.Latency),
+				ServiceNs: uint64(b.Service),
+				Error:     b.Error,
+			}}
+		}
+		if c := event.Capability; c != nil {
+			resp.Payload = &pb.Event_Capability{Capability: &pb.Capability{
+				Cap:     c.Cap,
+				Name:    c.Name,
+				Allowed: c.Allowed,
+				Opts:    c.Opts,
+			}}
+		}
+		if s := event.Signal; s != nil {
+			resp.Payload = &pb.Event_Signal{Signal: &pb.Signal{
+				Source:     s.Source,
+				Signal:     s.Signal,
+				SignalName: signalName(s.Signal),
+				Code:       s.Code,
+				Result:     s.Result,
+				SenderPid:  s.SenderPID,
+				SenderComm: sanitizeString(s.SenderComm),
+				TargetPid:  int32(s.TargetPID),
+				TargetTid:  s.TargetTID,
+				TargetComm: sanitizeString(s.TargetComm),
+			}}
 		}
 
 		if err := stream.Send(resp); err != nil {
@@ -53,6 +309,152 @@ func (e *Exporter) StreamEvents(req *pb.EventRequest, stream pb.TracerService_St
 	return nil
 }
 
+func namespacesToProto(s NamespaceSet) *pb.Namespaces {
+	return &pb.Namespaces{
+		Mnt:    s.Mnt,
+		Pid:    s.PID,
+		Net:    s.Net,
+		Uts:    s.UTS,
+		Ipc:    s.IPC,
+		User:   s.User,
+		Cgroup: s.Cgroup,
+		Time:   s.Time,
+	}
+}
+
+func (e *Exporter) GetProcessTree(ctx context.Context, req *pb.ProcessTreeRequest) (*pb.ProcessTreeResponse, error) {
+	resp := &pb.ProcessTreeResponse{}
+	for _, n := range e.tree.Snapshot(req.RootPid) {
+		node := &pb.ProcessNode{
+			Pid:    n.PID,
+			Ppid:   n.PPID,
+			Comm:   sanitizeString(n.Comm),
+			Exe:    sanitizeString(n.Exe),
+			Exited: n.Exited,
+		}
+		if !n.Started.IsZero() {
+			node.Started = timestamppb.New(n.Started)
+		}
+		resp.Processes = append(resp.Processes, node)
+	}
+	return resp, nil
+}
+
+// GetSummary returns the in-kernel syscall totals collected in --summary mode.
+func (e *Exporter) GetSummary(ctx context.Context, req *pb.SummaryRequest) (*pb.SummaryResponse, error) {
+	if !e.loader.SummaryEnabled() {
+		return nil, status.Error(codes.FailedPrecondition, "summary mode is off, start the tracer with --summary")
+	}
+	stats, err := collectSummary(e.loader, e.tree, req.Pid)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.SummaryResponse{}
+	for _, s := range stats {
+		resp.Syscalls = append(resp.Syscalls, &pb.SyscallSummary{
+			Pid:     s.PID,
+			Comm:    sanitizeString(s.Comm),
+			Nr:      s.Nr,
+			Name:    s.Name,
+			Calls:   s.Calls,
+			Errors:  s.Errors,
+			TotalNs: uint64(s.Total),
+			AvgNs:   uint64(s.Avg()),
+			MaxNs:   uint64(s.Max),
+		})
+	}
+	if resp.Lost, err = e.loader.SyscallSummaryLost(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
+
+// GetHistograms returns the in-kernel latency histograms collected in --hist mode.
+func (e *Exporter) GetHistograms(ctx context.Context, req *pb.HistogramRequest) (*pb.HistogramResponse, error) {
+	if e.loader.HistogramKeys() == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "histograms are off, start the tracer with --hist")
+	}
+	hists, err := collectHistograms(e.loader, e.tree, e.uprobes)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.HistogramResponse{}
+	for _, h := range hists {
+		if req.Pid != 0 && h.PID != req.Pid {
+			continue
+		}
+		if req.Type != "" && !strings.EqualFold(req.Type, h.Type) {
+			continue
+		}
+		if len(req.Names) > 0 && !containsString(req.Names, h.Name) {
+			continue
+		}
+		resp.Histograms = append(resp.Histograms, &pb.LatencyHistogram{
+			Type:    h.Type,
+			Pid:     h.PID,
+			Comm:    sanitizeString(h.Comm),
+			Name:    sanitizeString(h.Name),
+			Buckets: h.Buckets,
+			Count:   h.Count(),
+			SumNs:   uint64(h.Sum),
+			P50Ns:   uint64(h.Percentile(0.50)),
+			P90Ns:   uint64(h.Percentile(0.90)),
+			P99Ns:   uint64(h.Percentile(0.99)),
+			P999Ns:  uint64(h.Percentile(0.999)),
+		})
+	}
+	return resp, nil
+}
+
+// UpdateFilters replaces the in-kernel comm and/or path rules; a list that is
+// not set in the request is left as it is.
+func (e *Exporter) UpdateFilters(ctx context.Context, req *pb.FilterUpdate) (*pb.FilterState, error) {
+	// Без аутентификации фильтры меняет только локальный клиент
+	if !e.remoteFilters && !loopbackPeer(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "filters can only be changed from localhost; start the tracer with --allow-remote-filters")
+	}
+	if req.Comm != nil {
+		if err := e.loader.SetCommFilter(req.Comm.Rules); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "comm filter: %v", err)
+		}
+	}
+	if req.Path != nil {
+		if err := e.loader.SetPathFilter(req.Path.Rules); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "path filter: %v", err)
+		}
+	}
+	for _, set := range []struct {
+		rules *pb.RuleList
+		apply func([]string) error
+		name  string
+	}{
+		{req.SrcCidrs, func(r []string) error { return e.loader.SetCIDRFilter(false, r) }, "source CIDR"},
+		{req.DstCidrs, func(r []string) error { return e.loader.SetCIDRFilter(true, r) }, "destination CIDR"},
+		{req.Ports, e.loader.SetPortFilter, "port"},
+	} {
+		if set.rules == nil {
+			continue
+		}
+		if err := set.apply(set.rules.Rules); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s filter: %v", set.name, err)
+		}
+	}
+	if req.Direction != "" {
+		if err := e.loader.SetTCPDirection(req.Direction); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "direction: %v", err)
+		}
+	}
+	r := e.loader.FilterRules()
+	return &pb.FilterState{
+		CommRules: r.Comm,
+		PathRules: r.Path,
+		SrcCidrs:  r.SrcCIDR,
+		DstCidrs:  r.DstCIDR,
+		Ports:     r.Ports,
+		Direction: r.Direction,
+	}, nil
+}
+
 func containsUint32(list []uint32, val uint32) bool {
 	for _, v := range list {
 		if v == val {
@@ -71,8 +473,31 @@ func containsString(list []string, val string) bool {
 	return false
 }
 
-func StartGRPCServer(exporter *Exporter) {
-	lis, err := net.Listen("tcp", ":50051")
+// containsPrefix reports whether val starts with one of the non-empty prefixes.
+func containsPrefix(prefixes []string, val string) bool {
+	if val == "" {
+		return false
+	}
+	for _, p := range prefixes {
+		if p != "" && strings.HasPrefix(val, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopbackPeer reports whether the caller connected over a loopback address.
+func loopbackPeer(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	addr, ok := p.Addr.(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
+
+func StartGRPCServer(exporter *Exporter, addr string) {
+	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
@@ -80,7 +505,7 @@ func StartGRPCServer(exporter *Exporter) {
 	grpcServer := grpc.NewServer()
 	pb.RegisterTracerServiceServer(grpcServer, exporter)
 
-	log.Println("gRPC server listening on :50051")
+	log.Printf("gRPC server listening on %s", lis.Addr())
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
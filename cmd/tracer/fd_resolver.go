@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Верхняя граница кэша; при переполнении просто начинаем заново
+const fdCacheMaxEntries = 65536
+
+// FDResolver turns (pid, fd) pairs into the file, pipe or socket they refer to.
+// Results of openat/accept are cached as they are observed; anything else,
+// including socket, socketpair and pipe fds, is looked up through
+// /proc/<pid>/fd on first use. CLOSE, dup2/dup3 over an fd, exec and EXIT
+// invalidate.
+type FDResolver struct {
+	mu      sync.Mutex
+	byPID   map[uint32]map[int32]string
+	entries int
+}
+
+func NewFDResolver() *FDResolver {
+	return &FDResolver{byPID: make(map[uint32]map[int32]string)}
+}
+
+func (r *FDResolver) set(pid uint32, fd int32, target string) {
+	if r.entries >= fdCacheMaxEntries {
+		r.byPID = make(map[uint32]map[int32]string)
+		r.entries = 0
+	}
+	fds := r.byPID[pid]
+	if fds == nil {
+		fds = make(map[int32]string)
+		r.byPID[pid] = fds
+	}
+	if _, ok := fds[fd]; !ok {
+		r.entries++
+	}
+	fds[fd] = target
+}
+
+// Opened records the path returned by a successful openat.
+func (r *FDResolver) Opened(pid uint32, fd int32, path string) {
+	if fd < 0 {
+		return
+	}
+	r.mu.Lock()
+	r.set(pid, fd, path)
+	r.mu.Unlock()
+}
+
+// Closed forgets a descriptor.
+func (r *FDResolver) Closed(pid uint32, fd int32) {
+	r.mu.Lock()
+	if fds := r.byPID[pid]; fds != nil {
+		if _, ok := fds[fd]; ok {
+			delete(fds, fd)
+			r.entries--
+		}
+	}
+	r.mu.Unlock()
+}
+
+// Exited forgets every descriptor of a process.
+func (r *FDResolver) Exited(pid uint32) {
+	r.mu.Lock()
+	r.entries -= len(r.byPID[pid])
+	delete(r.byPID, pid)
+	r.mu.Unlock()
+}
+
+// Resolve returns a description of what fd points to, or "" if unknown.
+func (r *FDResolver) Resolve(pid uint32, fd int32) string {
+	if fd < 0 {
+		return ""
+	}
+	r.mu.Lock()
+	target, ok := r.byPID[pid][fd]
+	r.mu.Unlock()
+	if ok {
+		return target
+	}
+
+	target = resolveProcFD(pid, fd)
+	if target == "" {
+		return ""
+	}
+	// Неподключённый сокет ещё может получить адрес, такое не кэшируем
+	if !strings.HasSuffix(target, "(unconnected)") {
+		r.mu.Lock()
+		r.set(pid, fd, target)
+		r.mu.Unlock()
+	}
+	return target
+}
+
+// resolveProcFD reads /proc/<pid>/fd/<fd> and expands socket inodes into
+// their protocol and addresses.
+func resolveProcFD(pid uint32, fd int32) string {
+	link, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, fd))
+	if err != nil {
+		return ""
+	}
+	if !strings.HasPrefix(link, "socket:[") {
+		return link // путь к файлу, pipe:[ino], anon_inode:[...]
+	}
+	inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+	if desc := lookupSocket(pid, inode); desc != "" {
+		return desc
+	}
+	return link
+}
+
+// lookupSocket finds a socket inode in /proc/<pid>/net/{tcp,tcp6,udp,udp6,unix}.
+func lookupSocket(pid uint32, inode string) string {
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		f, err := os.Open(fmt.Sprintf("/proc/%d/net/%s", pid, proto))
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		sc.Scan() // заголовок
+		for sc.Scan() {
+			// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 10 || fields[9] != inode {
+				continue
+			}
+			f.Close()
+			local, remote := parseProcNetAddr(fields[1]), parseProcNetAddr(fields[2])
+			switch {
+			case strings.HasPrefix(proto, "tcp") && fields[3] == "0A": // TCP_LISTEN
+				return fmt.Sprintf("%s %s (listening)", proto, local)
+			case strings.HasSuffix(remote, ":0"):
+				return fmt.Sprintf("%s %s (unconnected)", proto, local)
+			}
+			return fmt.Sprintf("%s %s -> %s", proto, local, remote)
+		}
+		f.Close()
+	}
+
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/unix", pid))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Scan()
+	for sc.Scan() {
+		// Num RefCount Protocol Flags Type St Inode Path
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 7 || fields[6] != inode {
+			continue
+		}
+		if len(fields) > 7 {
+			return "unix " + fields[7]
+		}
+		return "unix socket:[" + inode + "]"
+	}
+	return ""
+}
+
+// parseProcNetAddr decodes "0100007F:1F90" (IPv4) or the 32-hex-digit IPv6
+// form; the address is stored as native-endian 32-bit words.
+func parseProcNetAddr(s string) string {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return s
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || len(raw)%4 != 0 {
+		return s
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return s
+	}
+	return net.JoinHostPort(net.IP(raw).String(), strconv.FormatUint(port, 10))
+}
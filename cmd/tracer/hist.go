@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Число log2-корзин, HIST_SLOTS в tracer.h
+const histSlots = 40
+
+// Ширина столбца звёздочек, как у biolatency
+const histBarWidth = 40
+
+// LatencyHist is one --hist histogram. Bucket i counts calls that took
+// [2^i, 2^(i+1)) nanoseconds; the last bucket also counts everything longer.
+type LatencyHist struct {
+	Type    string // SYSCALL, UPROBE или BLOCK_IO
+	PID     uint32 // 0, если гистограммы не разбиты по процессам
+	Comm    string
+	ID      uint64 // номер syscall-а, адрес функции или dev_t
+	Name    string // "", если гистограммы не разбиты по именам
+	Buckets []uint64
+	Sum     time.Duration
+}
+
+// Count returns the number of calls.
+func (h LatencyHist) Count() uint64 {
+	var n uint64
+	for _, c := range h.Buckets {
+		n += c
+	}
+	return n
+}
+
+// Mean returns the average latency.
+func (h LatencyHist) Mean() time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(n)
+}
+
+// Percentile estimates the latency below which the fraction p of calls fall,
+// interpolating linearly inside the bucket.
+func (h LatencyHist) Percentile(p float64) time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	rank := p * float64(n)
+	var seen float64
+	for i, c := range h.Buckets {
+		if c == 0 {
+			continue
+		}
+		if seen+float64(c) >= rank {
+			low, high := bucketRange(i)
+			return time.Duration(float64(low) + (rank-seen)/float64(c)*float64(high-low))
+		}
+		seen += float64(c)
+	}
+	low, _ := bucketRange(len(h.Buckets) - 1)
+	return time.Duration(low)
+}
+
+// bucketRange returns the bounds of bucket i in nanoseconds, high exclusive.
+func bucketRange(i int) (uint64, uint64) {
+	if i == 0 {
+		return 0, 2
+	}
+	return 1 << i, 1 << (i + 1)
+}
+
+func histTypeName(t uint32) string {
+	switch t {
+	case EVENT_TYPE_SYSCALL:
+		return "SYSCALL"
+	case EVENT_TYPE_UPROBE:
+		return "UPROBE"
+	case EVENT_TYPE_BLOCK_IO:
+		return "BLOCK_IO"
+	}
+	return fmt.Sprintf("%d", t)
+}
+
+// parseHistKeys maps --hist-by, e.g. "pid,name", to HIST_BY_* bits. The event
+// type is always a key, so "type" alone gives one histogram per type.
+func parseHistKeys(s string) (uint64, error) {
+	var by uint64
+	for _, k := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "", "type":
+		case "pid":
+			by |= HIST_BY_PID
+		case "name", "syscall", "func", "device":
+			by |= HIST_BY_NAME
+		default:
+			return 0, fmt.Errorf("unknown histogram key %q (want type, pid or name)", k)
+		}
+	}
+	return by, nil
+}
+
+// collectHistograms reads the in-kernel histograms and names the syscalls,
+// functions, devices and processes they belong to.
+func collectHistograms(loader *Loader, tree *ProcessTree, uprobes *UprobeManager) ([]LatencyHist, error) {
+	hists, err := loader.LatencyHistograms()
+	if err != nil {
+		return nil, err
+	}
+	byName := loader.HistogramKeys()&HIST_BY_NAME != 0
+	for i := range hists {
+		h := &hists[i]
+		if h.PID != 0 {
+			h.Comm = tree.Comm(h.PID)
+		}
+		if !byName {
+			continue
+		}
+		switch h.Type {
+		case "SYSCALL":
+			h.Name = syscallName(uint32(h.ID))
+		case "UPROBE":
+			if h.Name = uprobes.FunctionName(h.ID); h.Name == "" {
+				h.Name = fmt.Sprintf("uprobe #%d", h.ID)
+			}
+		case "BLOCK_IO":
+			h.Name = blockDevices.Name(uint32(h.ID))
+		}
+	}
+	return hists, nil
+}
+
+// writeHistograms prints each histogram the way biolatency does, followed by
+// its percentiles.
+func writeHistograms(w io.Writer, hists []LatencyHist) {
+	sort.Slice(hists, func(i, j int) bool {
+		a, b := hists[i], hists[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.PID < b.PID
+	})
+	for _, h := range hists {
+		n := h.Count()
+		if n == 0 {
+			continue
+		}
+		title := h.Type
+		if h.Name != "" {
+			title += " " + h.Name
+		}
+		if h.PID != 0 {
+			title += fmt.Sprintf(" PID %d (%s)", h.PID, h.Comm)
+		}
+		fmt.Fprintf(w, "\n%s\n", title)
+		writeLog2Hist(w, h.Buckets)
+		fmt.Fprintf(w, "count %d, avg %v, p50 %v, p90 %v, p99 %v, p99.9 %v\n", n, h.Mean(),
+			h.Percentile(0.50), h.Percentile(0.90), h.Percentile(0.99), h.Percentile(0.999))
+	}
+}
+
+// writeLog2Hist prints the buckets from the first to the last non-empty one.
+func writeLog2Hist(w io.Writer, buckets []uint64) {
+	first, last := -1, -1
+	var max uint64
+	for i, c := range buckets {
+		if c == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+		if c > max {
+			max = c
+		}
+	}
+	if first < 0 {
+		return
+	}
+	fmt.Fprintf(w, "%28s : %-8s %s\n", "nsecs", "count", "distribution")
+	for i := first; i <= last; i++ {
+		low, high := bucketRange(i)
+		stars := int(math.Round(float64(buckets[i]) / float64(max) * histBarWidth))
+		fmt.Fprintf(w, "%12d -> %-12d : %-8d |%-*s|\n",
+			low, high-1, buckets[i], histBarWidth, strings.Repeat("*", stars))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	pb "ebpf-tracer/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// runHistCommand implements `tracer hist`: a client that fetches the
+// histograms of a tracer running with --hist and prints them.
+func runHistCommand(args []string) {
+	fs := flag.NewFlagSet("hist", flag.ExitOnError)
+	server := fs.String("server", "localhost:50051", "Address of the running tracer's gRPC server")
+	pid := fs.Uint("pid", 0, "Only histograms of this PID (needs --hist-by=pid on the tracer)")
+	typ := fs.String("type", "", "Only SYSCALL, UPROBE or BLOCK_IO histograms")
+	names := fs.String("names", "", "Comma-separated syscall, function or device names (needs --hist-by=name on the tracer)")
+	interval := fs.Duration("interval", 0, "Print again at this interval (0 = once)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s hist [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	conn, err := grpc.NewClient(*server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *server, err)
+	}
+	defer conn.Close()
+	client := pb.NewTracerServiceClient(conn)
+
+	req := &pb.HistogramRequest{Pid: uint32(*pid), Type: strings.ToUpper(*typ)}
+	for _, n := range strings.Split(*names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			req.Names = append(req.Names, n)
+		}
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		resp, err := client.GetHistograms(ctx, req)
+		cancel()
+		if err != nil {
+			log.Fatalf("GetHistograms: %v", err)
+		}
+		if *interval > 0 {
+			fmt.Printf("\n%s\n", time.Now().Format("15:04:05"))
+		}
+		hists := make([]LatencyHist, 0, len(resp.Histograms))
+		for _, h := range resp.Histograms {
+			hists = append(hists, LatencyHist{
+				Type:    h.Type,
+				PID:     h.Pid,
+				Comm:    h.Comm,
+				Name:    h.Name,
+				Buckets: h.Buckets,
+				Sum:     time.Duration(h.SumNs),
+			})
+		}
+		writeHistograms(os.Stdout, hists)
+		if *interval <= 0 {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
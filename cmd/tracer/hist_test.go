@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math/bits"
+	"strings"
+	"testing"
+	"time"
+)
+
+// kernelSlot повторяет log2_u64 и ограничение HIST_SLOTS из hist_record
+func kernelSlot(ns uint64) int {
+	slot := 0
+	if ns > 0 {
+		slot = bits.Len64(ns) - 1
+	}
+	if slot >= histSlots {
+		slot = histSlots - 1
+	}
+	return slot
+}
+
+func TestBucketRange(t *testing.T) {
+	tests := []struct {
+		ns   uint64
+		slot int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+		{3, 1},
+		{4, 2},
+		{1023, 9},
+		{1024, 10},
+		{uint64(time.Millisecond), 19},
+		{1<<39 - 1, 38},
+		{1 << 39, 39},
+		// Всё длиннее попадает в последнюю корзину
+		{1 << 45, 39},
+	}
+	for _, tt := range tests {
+		slot := kernelSlot(tt.ns)
+		if slot != tt.slot {
+			t.Errorf("%d ns: slot %d, want %d", tt.ns, slot, tt.slot)
+		}
+		low, high := bucketRange(slot)
+		if tt.ns < low || (tt.ns >= high && slot != histSlots-1) {
+			t.Errorf("%d ns: bucket %d is [%d, %d)", tt.ns, slot, low, high)
+		}
+	}
+	// Корзины идут подряд, без дыр и наложений
+	for i := 1; i < histSlots; i++ {
+		_, prevHigh := bucketRange(i - 1)
+		if low, _ := bucketRange(i); low != prevHigh {
+			t.Errorf("bucket %d starts at %d, previous ends at %d", i, low, prevHigh)
+		}
+	}
+}
+
+func buckets(counts map[int]uint64) []uint64 {
+	b := make([]uint64, histSlots)
+	for i, c := range counts {
+		b[i] = c
+	}
+	return b
+}
+
+func TestLatencyHistPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		buckets map[int]uint64
+		p       float64
+		want    time.Duration
+	}{
+		{name: "empty", buckets: nil, p: 0.5, want: 0},
+		{name: "median inside one bucket", buckets: map[int]uint64{10: 4}, p: 0.5, want: 1536},
+		{name: "quarter inside one bucket", buckets: map[int]uint64{10: 4}, p: 0.25, want: 1280},
+		{name: "max is the bucket's upper bound", buckets: map[int]uint64{10: 4}, p: 1, want: 2048},
+		{name: "min is the first bucket's lower bound", buckets: map[int]uint64{3: 1, 10: 1}, p: 0, want: 8},
+		{name: "empty buckets are skipped", buckets: map[int]uint64{3: 1, 10: 1}, p: 0.75, want: 1536},
+		{name: "first bucket starts at zero", buckets: map[int]uint64{0: 2}, p: 0.5, want: 1},
+		{name: "rank on a bucket boundary", buckets: map[int]uint64{1: 1, 2: 1}, p: 0.5, want: 4},
+		{name: "tail bucket", buckets: map[int]uint64{5: 3, 20: 1}, p: 0.875, want: 1<<20 + 1<<19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := LatencyHist{Buckets: buckets(tt.buckets)}
+			if got := h.Percentile(tt.p); got != tt.want {
+				t.Errorf("Percentile(%v) = %d, want %d", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencyHistMean(t *testing.T) {
+	h := LatencyHist{Buckets: buckets(map[int]uint64{2: 1, 7: 3}), Sum: 1000}
+	if n := h.Count(); n != 4 {
+		t.Errorf("Count = %d, want 4", n)
+	}
+	if m := h.Mean(); m != 250 {
+		t.Errorf("Mean = %v, want 250ns", m)
+	}
+	if m := (LatencyHist{Buckets: buckets(nil)}).Mean(); m != 0 {
+		t.Errorf("Mean of empty histogram = %v", m)
+	}
+}
+
+func TestWriteLog2Hist(t *testing.T) {
+	var buf strings.Builder
+	writeLog2Hist(&buf, buckets(map[int]uint64{1: 1, 3: 2}))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	// Заголовок и строки с первой по последнюю непустую корзину
+	want := []struct {
+		low, high, count string
+		stars            int
+	}{
+		{"2", "3", "1", 20},
+		{"4", "7", "0", 0},
+		{"8", "15", "2", histBarWidth},
+	}
+	if len(lines) != len(want)+1 {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want)+1, buf.String())
+	}
+	for i, w := range want {
+		line := lines[i+1]
+		f := strings.Fields(line)
+		if len(f) < 5 || f[0] != w.low || f[2] != w.high || f[4] != w.count {
+			t.Errorf("line %q, want %s -> %s : %s", line, w.low, w.high, w.count)
+		}
+		if n := strings.Count(line, "*"); n != w.stars {
+			t.Errorf("line %q: %d stars, want %d", line, n, w.stars)
+		}
+	}
+
+	buf.Reset()
+	writeLog2Hist(&buf, buckets(nil))
+	if buf.Len() != 0 {
+		t.Errorf("empty histogram printed %q", buf.String())
+	}
+}
+
+func TestParseHistKeys(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "type", want: 0},
+		{in: "pid", want: HIST_BY_PID},
+		{in: "Name", want: HIST_BY_NAME},
+		{in: "pid, syscall", want: HIST_BY_PID | HIST_BY_NAME},
+		{in: "type,device", want: HIST_BY_NAME},
+		{in: "comm", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHistKeys(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseHistKeys(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHistKeys(%q) = %#x, want %#x", tt.in, got, tt.want)
+		}
+	}
+}
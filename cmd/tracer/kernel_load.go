@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// Источник MODULE-события, MODULE_* в tracer.h
+const (
+	MODULE_INIT   = 1
+	MODULE_FINIT  = 2
+	MODULE_DELETE = 3
+)
+
+var moduleOpNames = map[uint32]string{
+	MODULE_INIT:   "init_module",
+	MODULE_FINIT:  "finit_module",
+	MODULE_DELETE: "delete_module",
+}
+
+// Команды bpf(), о которых сообщает ядро (enum bpf_cmd)
+var bpfCommandNames = map[int32]string{
+	0:  "BPF_MAP_CREATE",
+	5:  "BPF_PROG_LOAD",
+	8:  "BPF_PROG_ATTACH",
+	17: "BPF_RAW_TRACEPOINT_OPEN",
+	18: "BPF_BTF_LOAD",
+	28: "BPF_LINK_CREATE",
+}
+
+func bpfCommandName(cmd int32) string {
+	if name, ok := bpfCommandNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("BPF_CMD_%d", cmd)
+}
+
+// bpfTypeName names the type field of a BPF event, which depends on the command.
+func bpfTypeName(cmd int32, typ uint32) string {
+	switch bpfCommandNames[cmd] {
+	case "BPF_MAP_CREATE":
+		return ebpf.MapType(typ).String()
+	case "BPF_PROG_LOAD":
+		return ebpf.ProgramType(typ).String()
+	case "BPF_PROG_ATTACH", "BPF_LINK_CREATE":
+		return fmt.Sprintf("attach type %d", typ)
+	}
+	return ""
+}
+
+// dentryPath assembles a path collected by file_path_components on the kernel
+// side: dentry names from the file up to the root, each NUL-terminated. The
+// path is relative to the root of the file system the file lives on.
+func dentryPath(payload []byte) string {
+	var parts []string
+	for _, p := range strings.Split(string(payload), "\x00") {
+		if p != "" && p != "/" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Переменная окружения, по которой дочерний процесс понимает, что он — шим
+const launchShimEnv = "TRACER_LAUNCH_SHIM"
+
+// Launcher starts a command held before exec, so that its PID can be put
+// into pid_filters before the first instruction of the target runs.
+//
+// The child is the tracer binary itself re-executed as a shim: it blocks on a
+// pipe until Release is called and then execs the real command in place, so
+// the PID stays the same.
+type Launcher struct {
+	cmd     *exec.Cmd
+	release *os.File
+	started time.Time
+	done    chan struct{}
+	err     error
+}
+
+func NewLauncher(args []string) (*Launcher, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command given")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate tracer binary: %w", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create release pipe: %w", err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(self, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), launchShimEnv+"=1")
+	cmd.ExtraFiles = []*os.File{r} // fd 3 в шиме
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("start %s: %w", args[0], err)
+	}
+	return &Launcher{cmd: cmd, release: w, done: make(chan struct{})}, nil
+}
+
+func (l *Launcher) PID() int {
+	return l.cmd.Process.Pid
+}
+
+// Release lets the held child exec the target command.
+func (l *Launcher) Release() error {
+	l.started = time.Now()
+	_, err := l.release.Write([]byte{1})
+	l.release.Close()
+	if err != nil {
+		return fmt.Errorf("release child: %w", err)
+	}
+	go func() {
+		l.err = l.cmd.Wait()
+		close(l.done)
+	}()
+	return nil
+}
+
+// Done is closed once the command has exited.
+func (l *Launcher) Done() <-chan struct{} {
+	return l.done
+}
+
+// Summary describes how the command exited; valid after Done is closed.
+func (l *Launcher) Summary() string {
+	elapsed := time.Since(l.started).Round(time.Millisecond)
+	status := "exited with code 0"
+	if l.err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(l.err, &exitErr) {
+			return fmt.Sprintf("wait failed after %s: %v", elapsed, l.err)
+		}
+		ws, ok := exitErr.Sys().(syscall.WaitStatus)
+		switch {
+		case ok && ws.Signaled():
+			status = fmt.Sprintf("killed by signal %s", ws.Signal())
+		default:
+			status = fmt.Sprintf("exited with code %d", exitErr.ExitCode())
+		}
+	}
+	return fmt.Sprintf("PID %d %s after %s", l.PID(), status, elapsed)
+}
+
+// ExitCode returns the command's exit code, or 128+signal if it was killed.
+func (l *Launcher) ExitCode() int {
+	var exitErr *exec.ExitError
+	if !errors.As(l.err, &exitErr) {
+		if l.err != nil {
+			return 1
+		}
+		return 0
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// runLaunchShim runs in the child started by NewLauncher: wait for the
+// tracer to register our PID, then replace ourselves with the command.
+func runLaunchShim(args []string) {
+	release := os.NewFile(3, "release")
+	buf := make([]byte, 1)
+	if n, _ := release.Read(buf); n == 0 {
+		// Трейсер умер, не дождавшись запуска — не выполняем команду без трассировки
+		os.Exit(1)
+	}
+	release.Close()
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tracer: %v\n", err)
+		os.Exit(127)
+	}
+	env := make([]string, 0, len(os.Environ()))
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, launchShimEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	err = syscall.Exec(path, args, env)
+	fmt.Fprintf(os.Stderr, "tracer: exec %s: %v\n", path, err)
+	os.Exit(126)
+}
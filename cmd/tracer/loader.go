@@ -1,23 +1,310 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/features"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/rlimit"
 )
 
+// Indices into the config map, must match CONFIG_* in tracer.h
+const (
+	CONFIG_PID_FILTER      = 0
+	CONFIG_FOLLOW_CHILDREN = 1
+	CONFIG_CGROUP_FILTER   = 2
+	CONFIG_UID_FILTER      = 3
+	CONFIG_TID_FILTER      = 4
+	CONFIG_CAPTURE_BYTES   = 5
+	CONFIG_CAPTURE_PIDS    = 6
+	CONFIG_CAPTURE_FDS     = 7
+	CONFIG_COMM_FILTER     = 8
+	CONFIG_PATH_FILTER     = 9
+	CONFIG_SADDR_FILTER    = 10
+	CONFIG_DADDR_FILTER    = 11
+	CONFIG_PORT_FILTER     = 12
+	CONFIG_TCP_DIRECTION   = 13
+	CONFIG_SYSCALL_FILTER  = 14
+	CONFIG_TRACER_PID      = 15
+	CONFIG_SUMMARY         = 16
+	CONFIG_HIST            = 17
+	CONFIG_STACKS          = 18
+	CONFIG_EVENT_MASK      = 19
+)
+
+// Биты CONFIG_HIST, HIST_* в tracer.h
+const (
+	HIST_ENABLED = 1
+	HIST_BY_PID  = 2
+	HIST_BY_NAME = 4
+)
+
+// Режимы и значения comm/path фильтров (FILTER_* в tracer.h)
+const (
+	FILTER_OFF       = 0
+	FILTER_DENY_ONLY = 1
+	FILTER_ALLOWLIST = 2
+
+	FILTER_ALLOW = 1
+	FILTER_DENY  = 2
+)
+
+// Ключи LPM trie, struct comm_key/path_key в tracer.h
+type commFilterKey struct {
+	Prefixlen uint32
+	Comm      [16]byte
+}
+
+type pathFilterKey struct {
+	Prefixlen uint32
+	Path      [256]byte
+}
+
+type addrFilterKey struct {
+	Prefixlen uint32
+	Addr      [16]byte
+}
+
+// FilterRuleSet holds the in-kernel filter rules as they were given.
+type FilterRuleSet struct {
+	Comm      []string
+	Path      []string
+	SrcCIDR   []string
+	DstCIDR   []string
+	Ports     []string
+	Direction string
+}
+
+// Максимальный размер хвоста payload_event (MAX_PAYLOAD в tracer.h)
+const maxPayload = 4096
+
+// Привязка программы к tracepoint-у. optional: tracepoint может отсутствовать
+// (legacy syscalls вроде unlink/mkdir есть на x86_64, но не на arm64)
+type tracepointSpec struct {
+	prog     string
+	group    string
+	name     string
+	optional bool
+}
+
+var tracepoints = []tracepointSpec{
+	{"handle_openat", "syscalls", "sys_enter_openat", false},
+	{"handle_openat_exit", "syscalls", "sys_exit_openat", false},
+	{"handle_read", "syscalls", "sys_enter_read", false},
+	{"handle_read_exit", "syscalls", "sys_exit_read", false},
+	{"handle_write", "syscalls", "sys_enter_write", false},
+	{"handle_write_exit", "syscalls", "sys_exit_write", false},
+	{"handle_accept", "syscalls", "sys_enter_accept4", false},
+	{"handle_accept_exit", "syscalls", "sys_exit_accept4", false},
+	{"handle_connect", "syscalls", "sys_enter_connect", false},
+	{"handle_connect_exit", "syscalls", "sys_exit_connect", false},
+	{"handle_close", "syscalls", "sys_enter_close", false},
+	{"handle_dup", "syscalls", "sys_enter_dup3", false},
+	{"handle_dup_exit", "syscalls", "sys_exit_dup3", false},
+	{"handle_dup", "syscalls", "sys_enter_dup2", true},
+	{"handle_dup_exit", "syscalls", "sys_exit_dup2", true},
+	{"handle_sched_exec", "sched", "sched_process_exec", false},
+	{"handle_sched_exit", "sched", "sched_process_exit", false},
+
+	// Изменения файловой системы: свой вход на каждый syscall, общий выход
+	{"handle_unlinkat", "syscalls", "sys_enter_unlinkat", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_unlinkat", false},
+	{"handle_unlink", "syscalls", "sys_enter_unlink", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_unlink", true},
+	{"handle_rmdir", "syscalls", "sys_enter_rmdir", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_rmdir", true},
+	{"handle_renameat2", "syscalls", "sys_enter_renameat2", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_renameat2", false},
+	{"handle_renameat", "syscalls", "sys_enter_renameat", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_renameat", true},
+	{"handle_rename", "syscalls", "sys_enter_rename", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_rename", true},
+	{"handle_fchmodat", "syscalls", "sys_enter_fchmodat", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_fchmodat", false},
+	{"handle_chmod", "syscalls", "sys_enter_chmod", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_chmod", true},
+	{"handle_fchownat", "syscalls", "sys_enter_fchownat", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_fchownat", false},
+	{"handle_chown", "syscalls", "sys_enter_chown", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_chown", true},
+	{"handle_chown", "syscalls", "sys_enter_lchown", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_lchown", true},
+	{"handle_mkdirat", "syscalls", "sys_enter_mkdirat", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_mkdirat", false},
+	{"handle_mkdir", "syscalls", "sys_enter_mkdir", true},
+	{"handle_fs_exit", "syscalls", "sys_exit_mkdir", true},
+	{"handle_truncate", "syscalls", "sys_enter_truncate", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_truncate", false},
+	{"handle_ftruncate", "syscalls", "sys_enter_ftruncate", false},
+	{"handle_fs_exit", "syscalls", "sys_exit_ftruncate", false},
+
+	// Отправка сигналов
+	{"handle_kill", "syscalls", "sys_enter_kill", false},
+	{"handle_kill_exit", "syscalls", "sys_exit_kill", false},
+	{"handle_tgkill", "syscalls", "sys_enter_tgkill", false},
+	{"handle_tgkill_exit", "syscalls", "sys_exit_tgkill", false},
+	{"handle_tkill", "syscalls", "sys_enter_tkill", false},
+	{"handle_tkill_exit", "syscalls", "sys_exit_tkill", false},
+
+	// Загрузка кода в ядро; без CONFIG_MODULES module-tracepoint-ов нет
+	{"handle_init_module", "syscalls", "sys_enter_init_module", true},
+	{"handle_init_module_exit", "syscalls", "sys_exit_init_module", true},
+	{"handle_finit_module", "syscalls", "sys_enter_finit_module", true},
+	{"handle_finit_module_exit", "syscalls", "sys_exit_finit_module", true},
+	{"handle_delete_module", "syscalls", "sys_enter_delete_module", true},
+	{"handle_delete_module_exit", "syscalls", "sys_exit_delete_module", true},
+	{"handle_module_load", "module", "module_load", true},
+
+	// Namespace-ы и монтирование; umount2 в tracefs называется sys_enter_umount
+	{"handle_setns", "syscalls", "sys_enter_setns", false},
+	{"handle_ns_exit", "syscalls", "sys_exit_setns", false},
+	{"handle_unshare", "syscalls", "sys_enter_unshare", false},
+	{"handle_ns_exit", "syscalls", "sys_exit_unshare", false},
+	{"handle_mount", "syscalls", "sys_enter_mount", false},
+	{"handle_mount_exit", "syscalls", "sys_exit_mount", false},
+	{"handle_umount", "syscalls", "sys_enter_umount", false},
+	{"handle_umount_exit", "syscalls", "sys_exit_umount", false},
+	{"handle_pivot_root", "syscalls", "sys_enter_pivot_root", false},
+	{"handle_pivot_root_exit", "syscalls", "sys_exit_pivot_root", false},
+}
+
+// BTF-enabled tracepoint programs (SEC("tp_btf/..."))
+var tracingPrograms = []string{
+	"handle_sched_fork",
+	"handle_signal_generate",
+	"handle_signal_deliver",
+}
+
+// Привязка программы к функции ядра; ret — kretprobe, optional — функции
+// может не быть (например, IPv6 собран модулем и не загружен)
+type kprobeSpec struct {
+	prog     string
+	symbol   string
+	ret      bool
+	optional bool
+}
+
+var kprobes = []kprobeSpec{
+	{"handle_tcp_connect", "tcp_connect", false, false},
+	{"handle_tcp_accept", "inet_csk_accept", true, false},
+	// static-функция: если компилятор её встроил, OOM-событий не будет
+	{"handle_oom_kill", "oom_kill_process", false, true},
+}
+
+// Программы SYSCALL-событий; не в tracepoints — привязываются только через EnableSyscalls
+var rawSyscallTracepoints = []tracepointSpec{
+	{"handle_raw_sys_enter", "raw_syscalls", "sys_enter", false},
+	{"handle_raw_sys_exit", "raw_syscalls", "sys_exit", false},
+}
+
+// Программы на горячих путях ядра, привязываемые через EnableEvents только
+// под типы из --events: иначе каждый capable(), пакет UDP или запрос диска
+// стоил бы запуска программы, даже когда событие отфильтровано
+type eventProbes struct {
+	mask        uint32 // типы событий, которые дают эти программы
+	tracepoints []tracepointSpec
+	kprobes     []kprobeSpec
+}
+
+var eventProbeSets = []eventProbes{
+	{mask: 1 << (EVENT_TYPE_CAPABLE - 1), kprobes: []kprobeSpec{
+		{"handle_cap_capable", "cap_capable", false, false},
+		{"handle_cap_capable_ret", "cap_capable", true, false},
+	}},
+	{mask: 1 << (EVENT_TYPE_CRED - 1), kprobes: []kprobeSpec{
+		{"handle_commit_creds", "commit_creds", false, false},
+	}},
+	{mask: 1<<(EVENT_TYPE_UDP-1) | 1<<(EVENT_TYPE_DNS-1), kprobes: []kprobeSpec{
+		{"handle_udp_send", "udp_sendmsg", false, false},
+		{"handle_udp_send_ret", "udp_sendmsg", true, false},
+		{"handle_udp_send", "udpv6_sendmsg", false, true},
+		{"handle_udp_send_ret", "udpv6_sendmsg", true, true},
+		{"handle_udp_recv", "skb_consume_udp", false, false},
+	}},
+	{mask: 1 << (EVENT_TYPE_MMAP_EXEC - 1), tracepoints: []tracepointSpec{
+		{"handle_mmap", "syscalls", "sys_enter_mmap", false},
+		{"handle_mmap_exit", "syscalls", "sys_exit_mmap", false},
+		{"handle_mprotect", "syscalls", "sys_enter_mprotect", false},
+		{"handle_mprotect_exit", "syscalls", "sys_exit_mprotect", false},
+	}, kprobes: []kprobeSpec{
+		// Без CONFIG_SECURITY хука нет — у MMAP_EXEC от mprotect не будет прежних флагов и файла
+		{"handle_file_mprotect", "security_file_mprotect", false, true},
+	}},
+	{mask: 1 << (EVENT_TYPE_BPF - 1), tracepoints: []tracepointSpec{
+		{"handle_bpf", "syscalls", "sys_enter_bpf", false},
+		{"handle_bpf_exit", "syscalls", "sys_exit_bpf", false},
+	}},
+	// Блочный ввод-вывод: каждый запрос диска стоил бы чтения task и записи в block_inflight
+	{mask: 1 << (EVENT_TYPE_BLOCK_IO - 1), tracepoints: []tracepointSpec{
+		{"handle_block_rq_insert", "block", "block_rq_insert", false},
+		{"handle_block_rq_issue", "block", "block_rq_issue", false},
+		{"handle_block_rq_complete", "block", "block_rq_complete", false},
+	}},
+}
+
 type Loader struct {
 	Collection *ebpf.Collection
 	Links      []link.Link
+
+	filterMu sync.Mutex
+	rules    FilterRuleSet
+	paths    map[string]uint8 // правила --path для PathPass: префикс -> FILTER_*
+
+	syscallsAttached bool
+	summary          bool
+	hist             uint64
+}
+
+type syscallStatKey struct {
+	PID uint32
+	Nr  uint32
+}
+
+type syscallStatValue struct {
+	Count   uint64
+	Errors  uint64
+	TotalNs uint64
+	MaxNs   uint64
+}
+
+// struct hist_key/hist в tracer.h
+type histKey struct {
+	Type uint32
+	PID  uint32
+	ID   uint64
+}
+
+type histValue struct {
+	Slots [histSlots]uint64
+	SumNs uint64
 }
 
-func NewLoader() (*Loader, error) {
+// LoaderOptions selects the optional parts of the collection.
+type LoaderOptions struct {
+	Stacks  bool   // --stacks: stack_traces во весь размер
+	Uprobes bool   // --uprobes: программы uprobe
+	MaxPIDs uint32 // --max-pids: размер pid_filters; 0 — как в tracer.bpf.c
+}
+
+// Программы uprobe: с cookie привязки (ядро 5.15+) и с адресом функции в ключе
+var (
+	uprobeCookiePrograms = []string{"handle_generic_uprobe", "handle_generic_uretprobe"}
+	uprobeAddrPrograms   = []string{"handle_generic_uprobe_addr", "handle_generic_uretprobe_addr"}
+)
+
+// NewLoader loads and attaches the event programs, leaving out those of
+// `tracer profile`. Without opts.Stacks the stack_traces map is cut down to
+// one entry, since it is preallocated. Uprobe programs are loaded only with
+// opts.Uprobes, in the variant the kernel supports.
+func NewLoader(opts LoaderOptions) (*Loader, error) {
 	if err := rlimit.RemoveMemlock(); err != nil {
 		return nil, fmt.Errorf("remove memlock: %w", err)
 	}
@@ -27,6 +314,36 @@ func NewLoader() (*Loader, error) {
 	if err != nil {
 		return nil, fmt.Errorf("load collection spec: %w", err)
 	}
+	if !opts.Stacks {
+		spec.Maps["stack_traces"].MaxEntries = 1
+	}
+	if opts.MaxPIDs != 0 {
+		spec.Maps["pid_filters"].MaxEntries = opts.MaxPIDs
+	}
+	// bpf_get_attach_cookie не пропустит верификатор ядра до 5.15: там
+	// загружаем варианты _addr, а без --uprobes — ни те, ни другие
+	var dropUprobes []string
+	switch {
+	case !opts.Uprobes:
+		dropUprobes = append(append(dropUprobes, uprobeCookiePrograms...), uprobeAddrPrograms...)
+		delete(spec.Maps, "uprobe_inflight")
+		delete(spec.Maps, "uprobe_depth")
+		delete(spec.Maps, "uprobe_configs")
+	case features.HaveProgramHelper(ebpf.Kprobe, asm.FnGetAttachCookie) == nil:
+		dropUprobes = uprobeAddrPrograms
+	default:
+		log.Printf("Kernel has no BPF cookies (5.15+): uprobes are matched by address, which works only for non-PIE executables")
+		dropUprobes = uprobeCookiePrograms
+	}
+	for _, name := range dropUprobes {
+		delete(spec.Programs, name)
+	}
+	// Профилировщик загружает своё сам (NewProfiler), его карты стеков
+	// предвыделены и демону не нужны
+	delete(spec.Programs, "handle_profile")
+	delete(spec.Maps, "profile_stacks")
+	delete(spec.Maps, "profile_counts")
+	delete(spec.Maps, "profile_dropped")
 
 	coll, err := ebpf.NewCollection(spec)
 	if err != nil {
@@ -36,91 +353,64 @@ func NewLoader() (*Loader, error) {
 	links := []link.Link{}
 
 	// Линкуем все стандартные программы
-	if prog := coll.Programs["handle_execve"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_execve", prog, nil)
-		if err != nil {
-			coll.Close()
-			return nil, fmt.Errorf("link execve: %w", err)
-		}
-		links = append(links, tp)
-	}
-	if prog := coll.Programs["handle_openat"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_openat", prog, nil)
-		if err != nil {
-			coll.Close()
-			return nil, fmt.Errorf("link openat: %w", err)
+	for _, t := range tracepoints {
+		prog := coll.Programs[t.prog]
+		if prog == nil {
+			continue
 		}
-		links = append(links, tp)
-	}
-	if prog := coll.Programs["handle_read"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_read", prog, nil)
-		if err != nil {
-			coll.Close()
-			return nil, fmt.Errorf("link read: %w", err)
-		}
-		links = append(links, tp)
-	}
-	if prog := coll.Programs["handle_write"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_write", prog, nil)
+		tp, err := link.Tracepoint(t.group, t.name, prog, nil)
 		if err != nil {
+			if t.optional && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			for _, l := range links {
+				l.Close()
+			}
 			coll.Close()
-			return nil, fmt.Errorf("link write: %w", err)
+			return nil, fmt.Errorf("link %s/%s: %w", t.group, t.name, err)
 		}
 		links = append(links, tp)
 	}
-	if prog := coll.Programs["handle_accept"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_accept4", prog, nil)
-		if err != nil {
-			coll.Close()
-			return nil, fmt.Errorf("link accept4: %w", err)
+	for _, k := range kprobes {
+		prog := coll.Programs[k.prog]
+		if prog == nil {
+			continue
 		}
-		links = append(links, tp)
-	}
-	if prog := coll.Programs["handle_connect"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_connect", prog, nil)
-		if err != nil {
-			coll.Close()
-			return nil, fmt.Errorf("link connect: %w", err)
+		attach := link.Kprobe
+		if k.ret {
+			attach = link.Kretprobe
 		}
-		links = append(links, tp)
-	}
-	if prog := coll.Programs["handle_clone"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_clone", prog, nil)
+		kp, err := attach(k.symbol, prog, nil)
 		if err != nil {
+			if k.optional && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			for _, l := range links {
+				l.Close()
+			}
 			coll.Close()
-			return nil, fmt.Errorf("link clone: %w", err)
+			return nil, fmt.Errorf("link %s: %w", k.symbol, err)
 		}
-		links = append(links, tp)
+		links = append(links, kp)
 	}
-	if prog := coll.Programs["handle_exit"]; prog != nil {
-		tp, err := link.Tracepoint("syscalls", "sys_enter_exit_group", prog, nil)
-		if err != nil {
-			coll.Close()
-			return nil, fmt.Errorf("link exit_group: %w", err)
+	// tp_btf: точка привязки задана в самой программе
+	for _, name := range tracingPrograms {
+		prog := coll.Programs[name]
+		if prog == nil {
+			continue
 		}
-		links = append(links, tp)
-	}
-	if prog := coll.Programs["handle_tcp_connect"]; prog != nil {
-		kp, err := link.Kprobe("tcp_connect", prog, nil)
+		tr, err := link.AttachTracing(link.TracingOptions{Program: prog})
 		if err != nil {
+			for _, l := range links {
+				l.Close()
+			}
 			coll.Close()
-			return nil, fmt.Errorf("link tcp_connect: %w", err)
+			return nil, fmt.Errorf("link %s: %w", name, err)
 		}
-		links = append(links, kp)
+		links = append(links, tr)
 	}
 	// UPROBE — только динамически, через UprobeManager
 
-	go func() {
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-		<-sig
-		for _, link := range links {
-			link.Close()
-		}
-		coll.Close()
-		os.Exit(0)
-	}()
-
 	return &Loader{
 		Collection: coll,
 		Links:      links,
@@ -134,7 +424,24 @@ func (l *Loader) Close() {
 	l.Collection.Close()
 }
 
+// SetConfig writes one of the CONFIG_* switches into the config array map.
+func (l *Loader) SetConfig(key uint32, value uint64) error {
+	m := l.Collection.Maps["config"]
+	if m == nil {
+		return fmt.Errorf("config map not found")
+	}
+	if err := m.Put(key, value); err != nil {
+		return fmt.Errorf("set config %d: %w", key, err)
+	}
+	return nil
+}
+
+// SetFilters sets the event types to trace: for every process, or, with a
+// non-zero pid, for that process only.
 func (l *Loader) SetFilters(pid int, eventMask uint32) error {
+	if err := l.SetConfig(CONFIG_EVENT_MASK, uint64(eventMask)); err != nil {
+		return err
+	}
 	if pid != 0 {
 		m := l.Collection.Maps["pid_filters"]
 		if m == nil {
@@ -144,51 +451,618 @@ func (l *Loader) SetFilters(pid int, eventMask uint32) error {
 		if err := m.Put(key, eventMask); err != nil {
 			return fmt.Errorf("set pid filter: %w", err)
 		}
+		// Процессы вне pid_filters отсекаются прямо в ядре
+		if err := l.SetConfig(CONFIG_PID_FILTER, 1); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func parseEventFilter(filter string) uint32 {
-	var mask uint32
-	fields := strings.Split(filter, ",")
-	if contains(fields, "execve") {
-		mask |= 1 << (EVENT_TYPE_EXECVE - 1)
+// SetCgroupFilter restricts tracing to the given cgroup v2 paths and the
+// cgroups below them.
+func (l *Loader) SetCgroupFilter(paths []string) error {
+	m := l.Collection.Maps["cgroup_filters"]
+	if m == nil {
+		return fmt.Errorf("cgroup_filters map not found")
 	}
-	if contains(fields, "open") {
-		mask |= 1 << (EVENT_TYPE_OPEN - 1)
+	n, err := fillCgroupFilter(m, paths)
+	if err != nil || n == 0 {
+		return err
 	}
-	if contains(fields, "read") {
-		mask |= 1 << (EVENT_TYPE_READ - 1)
+	return l.SetConfig(CONFIG_CGROUP_FILTER, uint64(n))
+}
+
+// SetUIDFilter restricts tracing to processes running as the given UIDs.
+func (l *Loader) SetUIDFilter(uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
 	}
-	if contains(fields, "write") {
-		mask |= 1 << (EVENT_TYPE_WRITE - 1)
+	m := l.Collection.Maps["uid_filters"]
+	if m == nil {
+		return fmt.Errorf("uid_filters map not found")
 	}
-	if contains(fields, "accept") {
-		mask |= 1 << (EVENT_TYPE_ACCEPT - 1)
+	for _, uid := range uids {
+		if err := m.Put(uid, uint8(1)); err != nil {
+			return fmt.Errorf("set uid filter %d: %w", uid, err)
+		}
 	}
-	if contains(fields, "connect") {
-		mask |= 1 << (EVENT_TYPE_CONNECT - 1)
+	return l.SetConfig(CONFIG_UID_FILTER, 1)
+}
+
+// SetTIDFilter restricts tracing to the given thread IDs.
+func (l *Loader) SetTIDFilter(tids []uint32) error {
+	if len(tids) == 0 {
+		return nil
 	}
-	if contains(fields, "clone") {
-		mask |= 1 << (EVENT_TYPE_CLONE - 1)
+	m := l.Collection.Maps["tid_filters"]
+	if m == nil {
+		return fmt.Errorf("tid_filters map not found")
 	}
-	if contains(fields, "exit") {
-		mask |= 1 << (EVENT_TYPE_EXIT - 1)
+	for _, tid := range tids {
+		if err := m.Put(tid, uint8(1)); err != nil {
+			return fmt.Errorf("set tid filter %d: %w", tid, err)
+		}
 	}
-	if contains(fields, "tcp_conn") {
-		mask |= 1 << (EVENT_TYPE_TCP_CONN - 1)
+	return l.SetConfig(CONFIG_TID_FILTER, 1)
+}
+
+// SetCommFilter replaces the process name rules. "name" matches exactly (names
+// are cut to 15 bytes like the kernel's comm), "name*" is a prefix and a
+// leading "!" excludes. An empty list turns the filter off.
+func (l *Loader) SetCommFilter(rules []string) error {
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	entries := make(map[interface{}]uint8)
+	for _, r := range rules {
+		name, action := parseFilterRule(r)
+		if name == "" {
+			continue
+		}
+		var key commFilterKey
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			if len(prefix) > len(key.Comm)-1 {
+				prefix = prefix[:len(key.Comm)-1]
+			}
+			copy(key.Comm[:], prefix)
+			key.Prefixlen = uint32(len(prefix)) * 8
+		} else {
+			if len(name) > len(key.Comm)-1 {
+				name = name[:len(key.Comm)-1]
+			}
+			// Точное совпадение — префикс вместе с завершающим NUL
+			copy(key.Comm[:], name)
+			key.Prefixlen = uint32(len(name)+1) * 8
+		}
+		entries[key] = action
 	}
-	if contains(fields, "uprobe") {
-		mask |= 1 << (EVENT_TYPE_UPROBE - 1)
+	if err := l.replaceRules("comm_filters", CONFIG_COMM_FILTER, entries); err != nil {
+		return err
 	}
-	return mask
+	l.rules.Comm = normalizeRules(rules)
+	return nil
+}
+
+// SetPathFilter replaces the path prefix rules applied to open and execve.
+// Absolute paths are matched in the kernel as passed to the syscall; relative
+// ones are left to PathPass once resolved. A leading "!" excludes.
+func (l *Loader) SetPathFilter(rules []string) error {
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	entries := make(map[interface{}]uint8)
+	paths := make(map[string]uint8)
+	for _, r := range rules {
+		prefix, action := parseFilterRule(r)
+		if prefix == "" {
+			continue
+		}
+		var key pathFilterKey
+		if len(prefix) > len(key.Path)-1 {
+			return fmt.Errorf("path prefix too long: %.40s...", prefix)
+		}
+		copy(key.Path[:], prefix)
+		key.Prefixlen = uint32(len(prefix)) * 8
+		entries[key] = action
+		paths[prefix] = action
+	}
+	if err := l.replaceRules("path_filters", CONFIG_PATH_FILTER, entries); err != nil {
+		return err
+	}
+	l.rules.Path = normalizeRules(rules)
+	l.paths = paths
+	return nil
+}
+
+// PathPass applies the --path rules to a path the processor resolved against
+// the cwd or a dirfd, which the kernel cannot do.
+func (l *Loader) PathPass(path string) bool {
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	return pathPass(l.paths, path)
+}
+
+// pathPass matches like the path_filters trie: the longest matching prefix
+// decides; with no match the path passes unless there are allow rules.
+func pathPass(rules map[string]uint8, path string) bool {
+	best, hasAllow, pass := -1, false, false
+	for prefix, action := range rules {
+		if action == FILTER_ALLOW {
+			hasAllow = true
+		}
+		if len(prefix) > best && strings.HasPrefix(path, prefix) {
+			best, pass = len(prefix), action == FILTER_ALLOW
+		}
+	}
+	if best < 0 {
+		return !hasAllow
+	}
+	return pass
+}
+
+// SetCIDRFilter replaces the source (dst == false) or destination address
+// rules for TCP_CONN, UDP and DNS events.
+func (l *Loader) SetCIDRFilter(dst bool, rules []string) error {
+	parsed, err := parseCIDRRules(rules)
+	if err != nil {
+		return err
+	}
+	entries := make(map[interface{}]uint8)
+	for _, r := range parsed {
+		entries[addrFilterKey{Prefixlen: uint32(r.prefix.Bits()), Addr: r.prefix.Addr().As16()}] = r.action
+	}
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	if dst {
+		if err := l.replaceRules("daddr_filters", CONFIG_DADDR_FILTER, entries); err != nil {
+			return err
+		}
+		l.rules.DstCIDR = normalizeRules(rules)
+		return nil
+	}
+	if err := l.replaceRules("saddr_filters", CONFIG_SADDR_FILTER, entries); err != nil {
+		return err
+	}
+	l.rules.SrcCIDR = normalizeRules(rules)
+	return nil
+}
+
+// SetPortFilter replaces the destination port rules for TCP_CONN, UDP and DNS
+// events.
+func (l *Loader) SetPortFilter(rules []string) error {
+	parsed, err := parsePortRules(rules)
+	if err != nil {
+		return err
+	}
+	entries := make(map[interface{}]uint8)
+	for port, action := range parsed {
+		entries[port] = action
+	}
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	if err := l.replaceRules("port_filters", CONFIG_PORT_FILTER, entries); err != nil {
+		return err
+	}
+	l.rules.Ports = normalizeRules(rules)
+	return nil
+}
+
+// SetTCPDirection limits TCP_CONN, UDP and DNS events to "in", "out" or
+// "both" directions.
+func (l *Loader) SetTCPDirection(direction string) error {
+	dir, err := parseDirection(direction)
+	if err != nil {
+		return err
+	}
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	if err := l.SetConfig(CONFIG_TCP_DIRECTION, uint64(dir)); err != nil {
+		return err
+	}
+	l.rules.Direction = directionName(dir)
+	return nil
+}
+
+// EnableSyscalls attaches the raw syscall programs, which report every
+// syscall as a SYSCALL event. rules are syscall names or numbers, "!" in
+// front excludes; an empty list traces all syscalls.
+func (l *Loader) EnableSyscalls(rules []string) error {
+	entries := make(map[interface{}]uint8)
+	for _, r := range rules {
+		s, action := parseFilterRule(r)
+		if s == "" || s == "all" {
+			continue
+		}
+		nr, ok := syscallNumber(s)
+		if !ok {
+			return fmt.Errorf("unknown syscall %q", s)
+		}
+		entries[nr] = action
+	}
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	if err := l.replaceRules("syscall_filters", CONFIG_SYSCALL_FILTER, entries); err != nil {
+		return err
+	}
+	if err := l.SetConfig(CONFIG_TRACER_PID, uint64(os.Getpid())); err != nil {
+		return err
+	}
+	if l.syscallsAttached {
+		return nil
+	}
+	var links []link.Link
+	for _, t := range rawSyscallTracepoints {
+		tp, err := link.Tracepoint(t.group, t.name, l.Collection.Programs[t.prog], nil)
+		if err != nil {
+			for _, lk := range links {
+				lk.Close()
+			}
+			return fmt.Errorf("link %s/%s: %w", t.group, t.name, err)
+		}
+		links = append(links, tp)
+	}
+	l.Links = append(l.Links, links...)
+	l.syscallsAttached = true
+	return nil
+}
+
+// EnableEvents attaches the hot-path programs of the event types in mask
+// (bit 1<<(type-1), as in SetFilters): CAPABLE, CRED, UDP and DNS, MMAP_EXEC,
+// BPF and BLOCK_IO, the last also feeding the per-device histograms of
+// EnableHistograms. Types left out of mask cost nothing in the kernel.
+func (l *Loader) EnableEvents(mask uint32) error {
+	var links []link.Link
+	fail := func(err error) error {
+		for _, lk := range links {
+			lk.Close()
+		}
+		return err
+	}
+	for _, set := range eventProbeSets {
+		if mask&set.mask == 0 {
+			continue
+		}
+		for _, t := range set.tracepoints {
+			tp, err := link.Tracepoint(t.group, t.name, l.Collection.Programs[t.prog], nil)
+			if err != nil {
+				if t.optional && errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return fail(fmt.Errorf("link %s/%s: %w", t.group, t.name, err))
+			}
+			links = append(links, tp)
+		}
+		for _, k := range set.kprobes {
+			attach := link.Kprobe
+			if k.ret {
+				attach = link.Kretprobe
+			}
+			kp, err := attach(k.symbol, l.Collection.Programs[k.prog], nil)
+			if err != nil {
+				if k.optional && errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return fail(fmt.Errorf("link %s: %w", k.symbol, err))
+			}
+			links = append(links, kp)
+		}
+	}
+	l.Links = append(l.Links, links...)
+	return nil
+}
+
+// FilterRules returns the filter rules currently in effect.
+func (l *Loader) FilterRules() FilterRuleSet {
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	r := l.rules
+	r.Comm = append([]string(nil), r.Comm...)
+	r.Path = append([]string(nil), r.Path...)
+	r.SrcCIDR = append([]string(nil), r.SrcCIDR...)
+	r.DstCIDR = append([]string(nil), r.DstCIDR...)
+	r.Ports = append([]string(nil), r.Ports...)
+	return r
+}
+
+// replaceRules swaps the contents of an LPM filter map. While the map is
+// rewritten the filter is off, so no event is dropped by a half-built rule set.
+func (l *Loader) replaceRules(mapName string, config uint32, entries map[interface{}]uint8) error {
+	m := l.Collection.Maps[mapName]
+	if m == nil {
+		return fmt.Errorf("%s map not found", mapName)
+	}
+	if err := l.SetConfig(config, FILTER_OFF); err != nil {
+		return err
+	}
+	if err := clearMap(m); err != nil {
+		return fmt.Errorf("clear %s: %w", mapName, err)
+	}
+	mode := uint64(FILTER_OFF)
+	for key, action := range entries {
+		if err := m.Put(key, action); err != nil {
+			return fmt.Errorf("update %s: %w", mapName, err)
+		}
+		if action == FILTER_ALLOW {
+			mode = FILTER_ALLOWLIST
+		} else if mode == FILTER_OFF {
+			mode = FILTER_DENY_ONLY
+		}
+	}
+	return l.SetConfig(config, mode)
+}
+
+// parseFilterRule splits "!value" into the value and FILTER_DENY.
+func parseFilterRule(rule string) (string, uint8) {
+	rule = strings.TrimSpace(rule)
+	if v, ok := strings.CutPrefix(rule, "!"); ok {
+		return strings.TrimSpace(v), FILTER_DENY
+	}
+	return rule, FILTER_ALLOW
+}
+
+func normalizeRules(rules []string) []string {
+	var out []string
+	for _, r := range rules {
+		if r = strings.TrimSpace(r); r != "" && r != "!" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func clearMap(m *ebpf.Map) error {
+	for {
+		key, err := m.NextKeyBytes(nil)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return nil
+		}
+		if err := m.Delete(key); err != nil {
+			return err
+		}
+	}
+}
+
+// EnableSummary switches raw syscalls to in-kernel aggregation: instead of a
+// SYSCALL event per call, counts and latencies are summed per process and
+// syscall in syscall_stats. rules are as for EnableSyscalls.
+func (l *Loader) EnableSummary(rules []string) error {
+	if err := l.SetConfig(CONFIG_SUMMARY, 1); err != nil {
+		return err
+	}
+	if err := l.EnableSyscalls(rules); err != nil {
+		return err
+	}
+	l.filterMu.Lock()
+	l.summary = true
+	l.filterMu.Unlock()
+	return nil
+}
+
+// SummaryEnabled reports whether EnableSummary was called.
+func (l *Loader) SummaryEnabled() bool {
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	return l.summary
 }
 
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if strings.TrimSpace(s) == item {
-			return true
+// SyscallSummary reads the per-process syscall totals; pid 0 returns all processes.
+func (l *Loader) SyscallSummary(pid uint32) ([]SyscallStat, error) {
+	m := l.Collection.Maps["syscall_stats"]
+	if m == nil {
+		return nil, fmt.Errorf("syscall_stats map not found")
+	}
+	var (
+		out []SyscallStat
+		key syscallStatKey
+		val syscallStatValue
+	)
+	it := m.Iterate()
+	for it.Next(&key, &val) {
+		if pid != 0 && key.PID != pid {
+			continue
 		}
+		out = append(out, SyscallStat{
+			PID:    key.PID,
+			Nr:     key.Nr,
+			Name:   syscallName(key.Nr),
+			Calls:  val.Count,
+			Errors: val.Errors,
+			Total:  time.Duration(val.TotalNs),
+			Max:    time.Duration(val.MaxNs),
+		})
 	}
-	return false
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("read syscall_stats: %w", err)
+	}
+	return out, nil
+}
+
+// SyscallSummaryLost returns how many calls were not counted because
+// syscall_stats was full.
+func (l *Loader) SyscallSummaryLost() (uint64, error) {
+	m := l.Collection.Maps["syscall_stats_lost"]
+	if m == nil {
+		return 0, fmt.Errorf("syscall_stats_lost map not found")
+	}
+	var lost uint64
+	if err := m.Lookup(uint32(0), &lost); err != nil {
+		return 0, fmt.Errorf("read syscall_stats_lost: %w", err)
+	}
+	return lost, nil
+}
+
+// FollowLost returns how many descendants --follow could not add to
+// pid_filters because it was full; they and their own children go untraced.
+func (l *Loader) FollowLost() (uint64, error) {
+	m := l.Collection.Maps["follow_lost"]
+	if m == nil {
+		return 0, fmt.Errorf("follow_lost map not found")
+	}
+	var lost uint64
+	if err := m.Lookup(uint32(0), &lost); err != nil {
+		return 0, fmt.Errorf("read follow_lost: %w", err)
+	}
+	return lost, nil
+}
+
+// EnableHistograms makes the raw syscall and uprobe programs collect log2
+// latency histograms in latency_hists instead of sending events. by is a set
+// of HIST_BY_* bits; the event type is always part of the key. syscalls are
+// rules as for EnableSyscalls, nil leaves the raw syscall programs detached.
+func (l *Loader) EnableHistograms(by uint64, syscalls []string) error {
+	by |= HIST_ENABLED
+	if err := l.SetConfig(CONFIG_HIST, by); err != nil {
+		return err
+	}
+	if syscalls != nil {
+		if err := l.EnableSyscalls(syscalls); err != nil {
+			return err
+		}
+	}
+	l.filterMu.Lock()
+	l.hist = by
+	l.filterMu.Unlock()
+	return nil
+}
+
+// HistogramKeys returns the CONFIG_HIST bits, 0 if histograms are off.
+func (l *Loader) HistogramKeys() uint64 {
+	l.filterMu.Lock()
+	defer l.filterMu.Unlock()
+	return l.hist
+}
+
+// LatencyHistograms reads latency_hists. Names and process names are left
+// for the caller to fill in.
+func (l *Loader) LatencyHistograms() ([]LatencyHist, error) {
+	m := l.Collection.Maps["latency_hists"]
+	if m == nil {
+		return nil, fmt.Errorf("latency_hists map not found")
+	}
+	var (
+		out []LatencyHist
+		key histKey
+		val histValue
+	)
+	it := m.Iterate()
+	for it.Next(&key, &val) {
+		out = append(out, LatencyHist{
+			Type:    histTypeName(key.Type),
+			PID:     key.PID,
+			ID:      key.ID,
+			Buckets: append([]uint64(nil), val.Slots[:]...),
+			Sum:     time.Duration(val.SumNs),
+		})
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("read latency_hists: %w", err)
+	}
+	return out, nil
+}
+
+// SetStacks makes events of the types in eventMask (bits as in pid_filters)
+// carry user and kernel stack ids. Only events reported from the traced
+// task's context support it: syscalls, file operations and uprobes.
+func (l *Loader) SetStacks(eventMask uint32) error {
+	return l.SetConfig(CONFIG_STACKS, uint64(eventMask))
+}
+
+// SetCapture enables capturing up to limit bytes of read/write payloads for
+// the given PIDs and/or fds.
+func (l *Loader) SetCapture(limit int, pids, fds []uint32) error {
+	if limit <= 0 {
+		return nil
+	}
+	if limit >= maxPayload {
+		return fmt.Errorf("capture limit must be below %d bytes", maxPayload)
+	}
+	if len(pids) == 0 && len(fds) == 0 {
+		return fmt.Errorf("payload capture needs at least one PID or fd")
+	}
+	for _, set := range []struct {
+		mapName string
+		config  uint32
+		keys    []uint32
+	}{
+		{"capture_pids", CONFIG_CAPTURE_PIDS, pids},
+		{"capture_fds", CONFIG_CAPTURE_FDS, fds},
+	} {
+		if len(set.keys) == 0 {
+			continue
+		}
+		m := l.Collection.Maps[set.mapName]
+		if m == nil {
+			return fmt.Errorf("%s map not found", set.mapName)
+		}
+		for _, k := range set.keys {
+			if err := m.Put(k, uint8(1)); err != nil {
+				return fmt.Errorf("update %s: %w", set.mapName, err)
+			}
+		}
+		if err := l.SetConfig(set.config, 1); err != nil {
+			return err
+		}
+	}
+	return l.SetConfig(CONFIG_CAPTURE_BYTES, uint64(limit))
+}
+
+// SetFollow makes children forked by a filtered PID inherit its filter entry.
+func (l *Loader) SetFollow(enabled bool) error {
+	var v uint64
+	if enabled {
+		v = 1
+	}
+	return l.SetConfig(CONFIG_FOLLOW_CHILDREN, v)
+}
+
+// Имена типов событий для --events
+var eventTypeNames = map[string]uint32{
+	"execve":    EVENT_TYPE_EXECVE,
+	"open":      EVENT_TYPE_OPEN,
+	"read":      EVENT_TYPE_READ,
+	"write":     EVENT_TYPE_WRITE,
+	"accept":    EVENT_TYPE_ACCEPT,
+	"connect":   EVENT_TYPE_CONNECT,
+	"clone":     EVENT_TYPE_CLONE,
+	"exit":      EVENT_TYPE_EXIT,
+	"tcp_conn":  EVENT_TYPE_TCP_CONN,
+	"tcp":       EVENT_TYPE_TCP_CONN,
+	"udp":       EVENT_TYPE_UDP,
+	"dns":       EVENT_TYPE_DNS,
+	"signal":    EVENT_TYPE_SIGNAL,
+	"cred":      EVENT_TYPE_CRED,
+	"capable":   EVENT_TYPE_CAPABLE,
+	"module":    EVENT_TYPE_MODULE,
+	"bpf":       EVENT_TYPE_BPF,
+	"mmap_exec": EVENT_TYPE_MMAP_EXEC,
+	"namespace": EVENT_TYPE_NAMESPACE,
+	"mount":     EVENT_TYPE_MOUNT,
+	"oom":       EVENT_TYPE_OOM,
+	"syscall":   EVENT_TYPE_SYSCALL,
+	"block":     EVENT_TYPE_BLOCK_IO,
+	"uprobe":    EVENT_TYPE_UPROBE,
+	"close":     EVENT_TYPE_CLOSE,
+	"data":      EVENT_TYPE_DATA,
+	"unlink":    EVENT_TYPE_UNLINK,
+	"rename":    EVENT_TYPE_RENAME,
+	"chmod":     EVENT_TYPE_CHMOD,
+	"chown":     EVENT_TYPE_CHOWN,
+	"mkdir":     EVENT_TYPE_MKDIR,
+	"rmdir":     EVENT_TYPE_RMDIR,
+	"truncate":  EVENT_TYPE_TRUNCATE,
+}
+
+func parseEventFilter(filter string) uint32 {
+	var mask uint32
+	for _, name := range strings.Split(filter, ",") {
+		name = strings.TrimSpace(name)
+		if t, ok := eventTypeNames[name]; ok {
+			mask |= 1 << (t - 1)
+		} else if name != "" {
+			log.Printf("Unknown event type %q ignored", name)
+		}
+	}
+	return mask
 }
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPathPass(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules map[string]uint8
+		path  string
+		want  bool
+	}{
+		{"no rules", nil, "/etc/passwd", true},
+		{"allowed prefix", map[string]uint8{"/etc": FILTER_ALLOW}, "/etc/passwd", true},
+		{"outside the allow list", map[string]uint8{"/etc": FILTER_ALLOW}, "/tmp/x", false},
+		// Префикс байтовый, как в LPM-ключе ядра
+		{"byte prefix", map[string]uint8{"/etc": FILTER_ALLOW}, "/etcfoo", true},
+		{"denied", map[string]uint8{"/proc": FILTER_DENY}, "/proc/self/stat", false},
+		{"deny only lets the rest pass", map[string]uint8{"/proc": FILTER_DENY}, "/etc/hosts", true},
+		{"longer deny wins", map[string]uint8{"/etc": FILTER_ALLOW, "/etc/ssl": FILTER_DENY}, "/etc/ssl/cert.pem", false},
+		{"longer allow wins", map[string]uint8{"/home": FILTER_DENY, "/home/app": FILTER_ALLOW}, "/home/app/log", true},
+		// Неразрешённый относительный путь не совпадает ни с одним правилом
+		{"unresolved relative path", map[string]uint8{"/etc": FILTER_ALLOW}, "passwd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pathPass(tt.rules, tt.path); got != tt.want {
+				t.Errorf("pathPass(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,8 +6,11 @@ import (
     "log"
     "os"
     "os/signal"
+    "strconv"
     "strings"
+    "sync/atomic"
     "syscall"
+    "time"
 )
 
 var (
@@ -15,16 +18,57 @@ var (
     eventFilter  = flag.String("events", "execve,open,tcp", "Comma-separated events")
     samplingRate = flag.Int("sampling", 1, "Sampling rate")
     uprobesFlag  = flag.String("uprobes", "", "Comma-separated uprobes in format 'binary:function' or 'binary:function:pid'")
+    followFlag   = flag.Bool("follow", false, "Also trace all descendants of --pid (like strace -f)")
+    maxPIDs      = flag.Uint("max-pids", 8192, "How many processes --pid with --follow can trace at once (size of the in-kernel PID filter)")
+    cgroupFlag   = flag.String("cgroup", "", "Comma-separated cgroup v2 paths to trace (relative to /sys/fs/cgroup), including their sub-cgroups")
+    uidFlag      = flag.String("uid", "", "Comma-separated user names or UIDs to trace")
+    tidFlag      = flag.String("tid", "", "Comma-separated thread IDs to trace")
+    captureBytes = flag.Int("capture-bytes", 0, "Capture up to N bytes of read/write payloads (0 = off, max 4095)")
+    capturePIDs  = flag.String("capture-pids", "", "Comma-separated PIDs whose read/write payloads are captured")
+    captureFDs   = flag.String("capture-fds", "", "Comma-separated fds whose read/write payloads are captured")
+    commFlag     = flag.String("comm", "", "Comma-separated process names to trace; 'name*' matches a prefix, '!name' excludes")
+    pathFlag     = flag.String("path", "", "Comma-separated path prefixes for open/execve events; '!prefix' excludes. Relative paths are checked after resolving them against the cwd or dirfd")
+    srcCIDRFlag  = flag.String("src-cidr", "", "Comma-separated source CIDRs for TCP/UDP traffic; '!cidr' excludes")
+    dstCIDRFlag  = flag.String("dst-cidr", "", "Comma-separated destination CIDRs for TCP/UDP traffic; '!cidr' excludes")
+    portFlag     = flag.String("port", "", "Comma-separated destination ports for TCP/UDP traffic; '!port' excludes")
+    tcpDirection = flag.String("direction", "both", "Direction of TCP/UDP traffic to trace: in, out or both")
+    summaryFlag  = flag.Bool("summary", false, "Count syscalls per process in the kernel and print an strace -c style table instead of SYSCALL events")
+    summaryEvery = flag.Duration("summary-interval", 0, "With --summary, also print the table at this interval (0 = only on exit)")
+    syscallsFlag = flag.String("syscalls", "", "Report raw syscalls as SYSCALL events: 'all' or comma-separated names/numbers; '!name' excludes")
+    histFlag     = flag.Bool("hist", false, "Collect log2 latency histograms of syscalls and --uprobes functions in the kernel instead of sending their events")
+    stacksFlag   = flag.String("stacks", "", "Comma-separated event types (uprobe, syscall, open, read, write, connect, accept, close, file operations) that capture user and kernel stacks")
+    histBy       = flag.String("hist-by", "name", "Comma-separated histogram keys besides the event type: pid, name (syscall, function or block device)")
+    listenAddr   = flag.String("listen", "127.0.0.1:50051", "Address of the gRPC server; it has no authentication, so bind it to other interfaces with care")
+    remoteFilter = flag.Bool("allow-remote-filters", false, "Accept UpdateFilters calls from non-loopback clients")
 )
 
+// Сколько ждём хвост событий из ringbuf после выхода запущенной команды
+const launchDrainDelay = 500 * time.Millisecond
+
 func main() {
+    // Дочерний шим из режима `tracer -- <command>` не должен разбирать флаги трейсера
+    if os.Getenv(launchShimEnv) != "" {
+        runLaunchShim(os.Args[1:])
+    }
+    if len(os.Args) > 1 && os.Args[1] == "hist" {
+        runHistCommand(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "profile" {
+        runProfileCommand(os.Args[2:])
+        return
+    }
+
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [-- command [args...]]\n       %s hist [flags]\n       %s profile [flags]\n", os.Args[0], os.Args[0], os.Args[0])
+        flag.PrintDefaults()
+    }
     flag.Parse()
 
-    loader, err := NewLoader()
+    loader, err := NewLoader(LoaderOptions{Stacks: *stacksFlag != "", Uprobes: *uprobesFlag != "", MaxPIDs: uint32(*maxPIDs)})
     if err != nil {
         log.Fatalf("Failed to load eBPF: %v", err)
     }
-    defer loader.Close()
 
     for name := range loader.Collection.Programs {
         log.Println("Program in collection:", name)
@@ -35,11 +79,159 @@ func main() {
     if err != nil {
         log.Fatalf("Failed to create UprobeManager: %v", err)
     }
-    defer uprobeManager.RemoveAll()
 
-    if err := loader.SetFilters(*pidFilter, parseEventFilter(*eventFilter)); err != nil {
+    // --- Launch-and-trace: команда стартует придержанной до exec ---
+    var launcher *Launcher
+    if flag.NArg() > 0 {
+        if *pidFilter != 0 {
+            log.Fatalf("--pid cannot be combined with a command to launch")
+        }
+        launcher, err = NewLauncher(flag.Args())
+        if err != nil {
+            log.Fatalf("Failed to launch command: %v", err)
+        }
+        *pidFilter = launcher.PID()
+        *followFlag = true
+    }
+
+    eventMask := parseEventFilter(*eventFilter)
+    if *summaryFlag {
+        // --summary считает syscall-ы, даже если их нет в --events
+        eventMask |= 1 << (EVENT_TYPE_SYSCALL - 1)
+    }
+    if *histFlag {
+        eventMask |= 1<<(EVENT_TYPE_SYSCALL-1) | 1<<(EVENT_TYPE_UPROBE-1)
+    }
+    // Типы, нужные самому трейсеру, даже если их нет в --events: имена хостов
+    // из DNS-ответов и CLOSE для кэша fd. Процессор их учитывает, но дальше не
+    // отдаёт. CLONE/EXECVE/EXIT ядро шлёт всегда, чужие — с EVENT_FLAG_HIDDEN
+    bookkeeping := uint32(0)
+    if eventMask&(1<<(EVENT_TYPE_TCP_CONN-1)|1<<(EVENT_TYPE_UDP-1)) != 0 {
+        bookkeeping |= 1 << (EVENT_TYPE_DNS - 1)
+    }
+    fdTypes := uint32(1<<(EVENT_TYPE_OPEN-1) | 1<<(EVENT_TYPE_READ-1) | 1<<(EVENT_TYPE_WRITE-1) |
+        1<<(EVENT_TYPE_ACCEPT-1) | 1<<(EVENT_TYPE_CONNECT-1) | 1<<(EVENT_TYPE_DATA-1) |
+        1<<(EVENT_TYPE_TRUNCATE-1) | 1<<(EVENT_TYPE_MMAP_EXEC-1))
+    if eventMask&fdTypes != 0 {
+        bookkeeping |= 1 << (EVENT_TYPE_CLOSE - 1)
+    }
+    hiddenMask := bookkeeping &^ eventMask
+    eventMask |= bookkeeping
+    if err := loader.SetFilters(*pidFilter, eventMask); err != nil {
         log.Fatalf("Failed to set filters: %v", err)
     }
+    if *cgroupFlag != "" {
+        if err := loader.SetCgroupFilter(strings.Split(*cgroupFlag, ",")); err != nil {
+            log.Fatalf("Failed to set cgroup filter: %v", err)
+        }
+    }
+    if *uidFlag != "" {
+        var uids []uint32
+        for _, u := range strings.Split(*uidFlag, ",") {
+            uid, err := LookupUID(u)
+            if err != nil {
+                log.Fatalf("Invalid --uid: %v", err)
+            }
+            uids = append(uids, uid)
+        }
+        if err := loader.SetUIDFilter(uids); err != nil {
+            log.Fatalf("Failed to set uid filter: %v", err)
+        }
+    }
+    if *tidFlag != "" {
+        tids, err := parseUint32List(*tidFlag)
+        if err != nil {
+            log.Fatalf("Invalid --tid: %v", err)
+        }
+        if err := loader.SetTIDFilter(tids); err != nil {
+            log.Fatalf("Failed to set tid filter: %v", err)
+        }
+    }
+    if *commFlag != "" {
+        if err := loader.SetCommFilter(strings.Split(*commFlag, ",")); err != nil {
+            log.Fatalf("Failed to set comm filter: %v", err)
+        }
+    }
+    if *pathFlag != "" {
+        if err := loader.SetPathFilter(strings.Split(*pathFlag, ",")); err != nil {
+            log.Fatalf("Failed to set path filter: %v", err)
+        }
+    }
+    if *srcCIDRFlag != "" {
+        if err := loader.SetCIDRFilter(false, strings.Split(*srcCIDRFlag, ",")); err != nil {
+            log.Fatalf("Invalid --src-cidr: %v", err)
+        }
+    }
+    if *dstCIDRFlag != "" {
+        if err := loader.SetCIDRFilter(true, strings.Split(*dstCIDRFlag, ",")); err != nil {
+            log.Fatalf("Invalid --dst-cidr: %v", err)
+        }
+    }
+    if *portFlag != "" {
+        if err := loader.SetPortFilter(strings.Split(*portFlag, ",")); err != nil {
+            log.Fatalf("Invalid --port: %v", err)
+        }
+    }
+    if err := loader.SetTCPDirection(*tcpDirection); err != nil {
+        log.Fatalf("Invalid --direction: %v", err)
+    }
+    if *summaryFlag {
+        // Без --syscalls считаем все
+        if err := loader.EnableSummary(strings.Split(*syscallsFlag, ",")); err != nil {
+            log.Fatalf("Failed to enable --summary: %v", err)
+        }
+    } else if *syscallsFlag != "" && !*histFlag {
+        if err := loader.EnableSyscalls(strings.Split(*syscallsFlag, ",")); err != nil {
+            log.Fatalf("Invalid --syscalls: %v", err)
+        }
+    }
+    if *histFlag {
+        by, err := parseHistKeys(*histBy)
+        if err != nil {
+            log.Fatalf("Invalid --hist-by: %v", err)
+        }
+        // С --uprobes или block — без raw syscalls, если их не просили явно; иначе syscall-ы из --syscalls (по умолчанию все)
+        var syscalls []string
+        if *syscallsFlag != "" || (*uprobesFlag == "" && eventMask&(1<<(EVENT_TYPE_BLOCK_IO-1)) == 0) {
+            syscalls = strings.Split(*syscallsFlag, ",")
+        }
+        if err := loader.EnableHistograms(by, syscalls); err != nil {
+            log.Fatalf("Failed to enable --hist: %v", err)
+        }
+        uprobeManager.SetLatency(true)
+    }
+    if err := loader.EnableEvents(eventMask); err != nil {
+        log.Fatalf("Failed to attach event programs: %v", err)
+    }
+    if *stacksFlag != "" {
+        if err := loader.SetStacks(parseEventFilter(*stacksFlag)); err != nil {
+            log.Fatalf("Failed to enable --stacks: %v", err)
+        }
+    }
+    if *captureBytes > 0 {
+        pids, err := parseUint32List(*capturePIDs)
+        if err != nil {
+            log.Fatalf("Invalid --capture-pids: %v", err)
+        }
+        fds, err := parseUint32List(*captureFDs)
+        if err != nil {
+            log.Fatalf("Invalid --capture-fds: %v", err)
+        }
+        if launcher != nil && len(pids) == 0 && len(fds) == 0 {
+            pids = []uint32{uint32(launcher.PID())}
+        }
+        if err := loader.SetCapture(*captureBytes, pids, fds); err != nil {
+            log.Fatalf("Failed to enable payload capture: %v", err)
+        }
+    }
+    if *followFlag {
+        if *pidFilter == 0 {
+            log.Fatalf("--follow requires --pid")
+        }
+        if err := loader.SetFollow(true); err != nil {
+            log.Fatalf("Failed to enable follow mode: %v", err)
+        }
+    }
 
     // --- Динамическое добавление uprobes по флагу ---
     if *uprobesFlag != "" {
@@ -54,6 +246,8 @@ func main() {
             pid := 0
             if len(parts) > 2 {
                 fmt.Sscanf(parts[2], "%d", &pid)
+            } else if launcher != nil {
+                pid = launcher.PID()
             }
             if err := uprobeManager.AddUprobe(pid, binary, fn); err != nil {
                 log.Printf("Failed to add uprobe %s: %v", spec, err)
@@ -65,7 +259,17 @@ func main() {
     processedEvents := make(chan *ProcessedEvent, 262144)
 
     reader := NewReader(loader.Collection)
-    processor := NewProcessor(uint32(*pidFilter), *samplingRate)
+    // В режиме --follow набор PID-ов ведёт ядро, пользовательский фильтр лишь мешает
+    procPID := uint32(*pidFilter)
+    if *followFlag {
+        procPID = 0
+    }
+    processor := NewProcessor(procPID, *samplingRate)
+    processor.hidden = hiddenMask
+    processor.pathPass = loader.PathPass
+    if *stacksFlag != "" {
+        processor.stacks = NewSymbolizer(loader.Collection.Maps["stack_traces"])
+    }
 
     go reader.Start(rawEvents)
     go processor.Start(rawEvents, processedEvents)
@@ -75,25 +279,141 @@ func main() {
     if err != nil {
         log.Fatalf("Failed to open log file: %v", err)
     }
-    defer logFile.Close()
     fileLogger := log.New(logFile, "", 0)
 
     go func() {
         for ev := range processedEvents {
             // Корректное форматирование времени
             ts := ev.Timestamp.Local().Format("2006-01-02 15:04:05.000")
-            fileLogger.Printf("%s | %s | PID=%d | COMM=%s | %s",
-                ts, ev.Type, ev.PID, ev.Comm, ev.Details)
+            line := fmt.Sprintf("%s | %s | PID=%d | TID=%d | COMM=%s | THREAD=%s | USER=%s",
+                ts, ev.Type, ev.PID, ev.TID, ev.Comm, ev.ThreadName, ev.User)
+            if ev.ContainerID != "" {
+                line += fmt.Sprintf(" | CONTAINER=%.12s", ev.ContainerID)
+            }
+            // Откуда взялся процесс — важнее всего при exec
+            if ev.Type == "EXECVE" && ev.Ancestry != "" {
+                line += " | TREE=" + ev.Ancestry
+            }
+            if len(ev.UserStack) > 0 || len(ev.KernelStack) > 0 {
+                line += " | STACK=" + formatStack(append(ev.KernelStack, ev.UserStack...))
+            }
+            fileLogger.Printf("%s | %s", line, ev.Details)
         }
     }()
 
-    exporter := NewExporter(processedEvents)
-    go StartGRPCServer(exporter)
+    exporter := NewExporter(processedEvents, processor.tree, loader, uprobeManager)
+    exporter.remoteFilters = *remoteFilter
+    go StartGRPCServer(exporter, *listenAddr)
+
+    printSummary := func() {
+        if !*summaryFlag {
+            return
+        }
+        stats, err := collectSummary(loader, processor.tree, 0)
+        if err != nil {
+            log.Printf("Failed to read syscall summary: %v", err)
+            return
+        }
+        writeSummary(os.Stderr, stats)
+        if lost, err := loader.SyscallSummaryLost(); err == nil && lost > 0 {
+            log.Printf("%d syscalls not counted: syscall_stats is full (65536 process/syscall pairs)", lost)
+        }
+    }
+    printHistograms := func() {
+        if !*histFlag {
+            return
+        }
+        hists, err := collectHistograms(loader, processor.tree, uprobeManager)
+        if err != nil {
+            log.Printf("Failed to read latency histograms: %v", err)
+            return
+        }
+        writeHistograms(os.Stderr, hists)
+    }
+    // Сообщаем только о новых потерях: вызывается и по таймеру, и при выходе
+    var followLost atomic.Uint64
+    printFollowLost := func() {
+        if !*followFlag {
+            return
+        }
+        lost, err := loader.FollowLost()
+        if err != nil || followLost.Swap(lost) == lost {
+            return
+        }
+        log.Printf("%d descendants of PID %d not traced: pid_filters is full, raise --max-pids (now %d)", lost, *pidFilter, *maxPIDs)
+    }
+    if *followFlag {
+        go func() {
+            for range time.Tick(10 * time.Second) {
+                printFollowLost()
+            }
+        }()
+    }
+    printStacksLost := func() {
+        if processor.stacks == nil {
+            return
+        }
+        if lost := processor.stacks.Lost(); lost > 0 {
+            log.Printf("%d stacks lost: their stack_traces bucket held another stack", lost)
+        }
+    }
+    if *summaryFlag && *summaryEvery > 0 {
+        go func() {
+            for range time.Tick(*summaryEvery) {
+                printSummary()
+            }
+        }()
+    }
 
-    log.Println("Tracer started. Press Ctrl+C to stop...")
+    // Итоги и очистка — ровно один раз, в том числе перед os.Exit, который
+    // отложенные вызовы не выполняет
+    shutdown := func() {
+        printSummary()
+        printHistograms()
+        printStacksLost()
+        printFollowLost()
+        uprobeManager.RemoveAll()
+        loader.Close()
+        logFile.Close()
+    }
 
     sig := make(chan os.Signal, 1)
     signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-    <-sig
+
+    if launcher != nil {
+        if err := launcher.Release(); err != nil {
+            log.Fatalf("Failed to start command: %v", err)
+        }
+        log.Printf("Tracing %s (PID %d)", strings.Join(flag.Args(), " "), launcher.PID())
+        select {
+        case <-launcher.Done():
+            time.Sleep(launchDrainDelay)
+            log.Printf("Command %s; %d events captured", launcher.Summary(), processor.Emitted())
+            shutdown()
+            os.Exit(launcher.ExitCode())
+        case <-sig:
+        }
+    } else {
+        log.Println("Tracer started. Press Ctrl+C to stop...")
+        <-sig
+    }
     log.Println("Shutting down tracer")
+    shutdown()
+}
+
+// parseUint32List разбирает список чисел через запятую; пустая строка — пустой список
+func parseUint32List(s string) ([]uint32, error) {
+    var out []uint32
+    for _, f := range strings.Split(s, ",") {
+        f = strings.TrimSpace(f)
+        if f == "" {
+            continue
+        }
+        v, err := strconv.ParseUint(f, 10, 32)
+        if err != nil {
+            return nil, err
+        }
+        out = append(out, uint32(v))
+    }
+    return out, nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Источник MMAP_EXEC-события, MMAP_OP_* в tracer.h
+const (
+	MMAP_OP_MMAP     = 1
+	MMAP_OP_MPROTECT = 2
+)
+
+var mmapOpNames = map[uint32]string{
+	MMAP_OP_MMAP:     "mmap",
+	MMAP_OP_MPROTECT: "mprotect",
+}
+
+var mmapFlagNames = []struct {
+	flag uint32
+	name string
+}{
+	{unix.MAP_FIXED, "MAP_FIXED"},
+	{unix.MAP_ANONYMOUS, "MAP_ANONYMOUS"},
+	{unix.MAP_GROWSDOWN, "MAP_GROWSDOWN"},
+	{unix.MAP_DENYWRITE, "MAP_DENYWRITE"},
+	{unix.MAP_EXECUTABLE, "MAP_EXECUTABLE"},
+	{unix.MAP_LOCKED, "MAP_LOCKED"},
+	{unix.MAP_NORESERVE, "MAP_NORESERVE"},
+	{unix.MAP_POPULATE, "MAP_POPULATE"},
+	{unix.MAP_NONBLOCK, "MAP_NONBLOCK"},
+	{unix.MAP_STACK, "MAP_STACK"},
+	{unix.MAP_HUGETLB, "MAP_HUGETLB"},
+	{unix.MAP_FIXED_NOREPLACE, "MAP_FIXED_NOREPLACE"},
+}
+
+// protString renders PROT_* (or the low VM_* bits, which match) as "rwx".
+func protString(prot uint32) string {
+	b := []byte("---")
+	if prot&unix.PROT_READ != 0 {
+		b[0] = 'r'
+	}
+	if prot&unix.PROT_WRITE != 0 {
+		b[1] = 'w'
+	}
+	if prot&unix.PROT_EXEC != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
+
+// mmapFlagsString decodes MAP_* flags, e.g. "MAP_PRIVATE|MAP_ANONYMOUS".
+func mmapFlagsString(flags uint32) string {
+	var parts []string
+	switch flags & unix.MAP_TYPE {
+	case unix.MAP_SHARED:
+		parts = append(parts, "MAP_SHARED")
+	case unix.MAP_PRIVATE:
+		parts = append(parts, "MAP_PRIVATE")
+	case unix.MAP_SHARED_VALIDATE:
+		parts = append(parts, "MAP_SHARED_VALIDATE")
+	}
+	rest := flags &^ unix.MAP_TYPE
+	for _, f := range mmapFlagNames {
+		if rest&f.flag != 0 {
+			parts = append(parts, f.name)
+			rest &^= f.flag
+		}
+	}
+	if rest != 0 {
+		parts = append(parts, fmt.Sprintf("0x%x", rest))
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Источник NAMESPACE- и MOUNT-событий, NS_OP_* и MOUNT_OP_* в tracer.h
+const (
+	NS_OP_SETNS   = 1
+	NS_OP_UNSHARE = 2
+
+	MOUNT_OP_MOUNT      = 1
+	MOUNT_OP_UMOUNT     = 2
+	MOUNT_OP_PIVOT_ROOT = 3
+)
+
+var nsOpNames = map[uint32]string{
+	NS_OP_SETNS:   "setns",
+	NS_OP_UNSHARE: "unshare",
+}
+
+var mountOpNames = map[uint32]string{
+	MOUNT_OP_MOUNT:      "mount",
+	MOUNT_OP_UMOUNT:     "umount2",
+	MOUNT_OP_PIVOT_ROOT: "pivot_root",
+}
+
+type flagName struct {
+	flag uint64
+	name string
+}
+
+// Флаги unshare и nstype у setns
+var cloneFlagNames = []flagName{
+	{unix.CLONE_NEWNS, "CLONE_NEWNS"},
+	{unix.CLONE_NEWCGROUP, "CLONE_NEWCGROUP"},
+	{unix.CLONE_NEWUTS, "CLONE_NEWUTS"},
+	{unix.CLONE_NEWIPC, "CLONE_NEWIPC"},
+	{unix.CLONE_NEWUSER, "CLONE_NEWUSER"},
+	{unix.CLONE_NEWPID, "CLONE_NEWPID"},
+	{unix.CLONE_NEWNET, "CLONE_NEWNET"},
+	{unix.CLONE_NEWTIME, "CLONE_NEWTIME"},
+	{unix.CLONE_FILES, "CLONE_FILES"},
+	{unix.CLONE_FS, "CLONE_FS"},
+	{unix.CLONE_SYSVSEM, "CLONE_SYSVSEM"},
+}
+
+var mountFlagNames = []flagName{
+	{unix.MS_RDONLY, "MS_RDONLY"},
+	{unix.MS_NOSUID, "MS_NOSUID"},
+	{unix.MS_NODEV, "MS_NODEV"},
+	{unix.MS_NOEXEC, "MS_NOEXEC"},
+	{unix.MS_SYNCHRONOUS, "MS_SYNCHRONOUS"},
+	{unix.MS_REMOUNT, "MS_REMOUNT"},
+	{unix.MS_MANDLOCK, "MS_MANDLOCK"},
+	{unix.MS_DIRSYNC, "MS_DIRSYNC"},
+	{unix.MS_NOSYMFOLLOW, "MS_NOSYMFOLLOW"},
+	{unix.MS_NOATIME, "MS_NOATIME"},
+	{unix.MS_NODIRATIME, "MS_NODIRATIME"},
+	{unix.MS_BIND, "MS_BIND"},
+	{unix.MS_MOVE, "MS_MOVE"},
+	{unix.MS_REC, "MS_REC"},
+	{unix.MS_SILENT, "MS_SILENT"},
+	{unix.MS_POSIXACL, "MS_POSIXACL"},
+	{unix.MS_UNBINDABLE, "MS_UNBINDABLE"},
+	{unix.MS_PRIVATE, "MS_PRIVATE"},
+	{unix.MS_SLAVE, "MS_SLAVE"},
+	{unix.MS_SHARED, "MS_SHARED"},
+	{unix.MS_RELATIME, "MS_RELATIME"},
+	{unix.MS_STRICTATIME, "MS_STRICTATIME"},
+	{unix.MS_LAZYTIME, "MS_LAZYTIME"},
+}
+
+var umountFlagNames = []flagName{
+	{unix.MNT_FORCE, "MNT_FORCE"},
+	{unix.MNT_DETACH, "MNT_DETACH"},
+	{unix.MNT_EXPIRE, "MNT_EXPIRE"},
+	{unix.UMOUNT_NOFOLLOW, "UMOUNT_NOFOLLOW"},
+}
+
+// decodeFlags names the set bits; unknown ones are kept as a hex number.
+func decodeFlags(flags uint64, names []flagName) []string {
+	var out []string
+	for _, f := range names {
+		if flags&f.flag != 0 {
+			out = append(out, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		out = append(out, fmt.Sprintf("0x%x", flags))
+	}
+	return out
+}
+
+// NamespaceSet holds the namespace inode numbers of a process, as shown by
+// /proc/<pid>/ns. PID is the namespace new children are created in.
+type NamespaceSet struct {
+	Mnt, PID, Net, UTS, IPC, User, Cgroup, Time uint32
+}
+
+// parseNamespaceSet decodes struct ns_inums.
+func parseNamespaceSet(b []byte) NamespaceSet {
+	u32 := func(i int) uint32 { return binary.LittleEndian.Uint32(b[i*4:]) }
+	return NamespaceSet{
+		Mnt: u32(0), PID: u32(1), Net: u32(2), UTS: u32(3),
+		IPC: u32(4), User: u32(5), Cgroup: u32(6), Time: u32(7),
+	}
+}
+
+// Changes lists the namespaces that differ, e.g. "net 4026531840→4026532301".
+func (s NamespaceSet) Changes(after NamespaceSet) []string {
+	var out []string
+	pairs := []struct {
+		name          string
+		before, after uint32
+	}{
+		{"mnt", s.Mnt, after.Mnt},
+		{"pid", s.PID, after.PID},
+		{"net", s.Net, after.Net},
+		{"uts", s.UTS, after.UTS},
+		{"ipc", s.IPC, after.IPC},
+		{"user", s.User, after.User},
+		{"cgroup", s.Cgroup, after.Cgroup},
+		{"time", s.Time, after.Time},
+	}
+	for _, p := range pairs {
+		if p.before != p.after {
+			out = append(out, fmt.Sprintf("%s %d→%d", p.name, p.before, p.after))
+		}
+	}
+	return out
+}
+
+func joinFlags(flags []string) string {
+	if len(flags) == 0 {
+		return "0"
+	}
+	return strings.Join(flags, "|")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Направление соединения, TCP_DIR_* в tracer.h
+const (
+	TCP_DIR_OUT = 1
+	TCP_DIR_IN  = 2
+)
+
+// cidrRule is one --src-cidr/--dst-cidr rule. IPv4 prefixes are kept in
+// IPv4-mapped form, the same way the kernel side stores addresses.
+type cidrRule struct {
+	prefix netip.Prefix
+	action uint8 // FILTER_ALLOW или FILTER_DENY
+}
+
+// parseCIDRRules accepts "10.0.0.0/8", "2001:db8::/32", a bare address, and
+// "!" in front of any of them to exclude.
+func parseCIDRRules(rules []string) ([]cidrRule, error) {
+	var out []cidrRule
+	for _, r := range rules {
+		s, action := parseFilterRule(r)
+		if s == "" {
+			continue
+		}
+		var prefix netip.Prefix
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", s, err)
+			}
+			prefix = p.Masked()
+		} else {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", s, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		out = append(out, cidrRule{prefix: mappedPrefix(prefix), action: action})
+	}
+	return out, nil
+}
+
+func mappedPrefix(p netip.Prefix) netip.Prefix {
+	if !p.Addr().Is4() {
+		return p
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(p.Addr().As16()), p.Bits()+96)
+}
+
+// cidrPass applies the rules like the LPM trie does: the longest matching
+// prefix decides; with no match the address passes unless there are allow rules.
+func cidrPass(rules []cidrRule, addr netip.Addr) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	addr = netip.AddrFrom16(addr.As16())
+	best, hasAllow, pass := -1, false, false
+	for _, r := range rules {
+		if r.action == FILTER_ALLOW {
+			hasAllow = true
+		}
+		if r.prefix.Bits() > best && r.prefix.Contains(addr) {
+			best, pass = r.prefix.Bits(), r.action == FILTER_ALLOW
+		}
+	}
+	if best < 0 {
+		return !hasAllow
+	}
+	return pass
+}
+
+// parsePortRules accepts port numbers, "!" in front excludes.
+func parsePortRules(rules []string) (map[uint16]uint8, error) {
+	out := make(map[uint16]uint8)
+	for _, r := range rules {
+		s, action := parseFilterRule(r)
+		if s == "" {
+			continue
+		}
+		port, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+		out[uint16(port)] = action
+	}
+	return out, nil
+}
+
+func portPass(rules map[uint16]uint8, port uint16) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	if action, ok := rules[port]; ok {
+		return action == FILTER_ALLOW
+	}
+	for _, action := range rules {
+		if action == FILTER_ALLOW {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDirection maps "in"/"out" (or "inbound"/"outbound") to TCP_DIR_*; "" and "both" are 0.
+func parseDirection(s string) (uint16, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "both":
+		return 0, nil
+	case "out", "outbound":
+		return TCP_DIR_OUT, nil
+	case "in", "inbound":
+		return TCP_DIR_IN, nil
+	}
+	return 0, fmt.Errorf("invalid direction %q (want in, out or both)", s)
+}
+
+func directionName(dir uint16) string {
+	switch dir {
+	case TCP_DIR_OUT:
+		return "outbound"
+	case TCP_DIR_IN:
+		return "inbound"
+	}
+	return ""
+}
+
+// NetMatcher is the client-side counterpart of the kernel network filters,
+// used for EventRequest subscriptions.
+type NetMatcher struct {
+	src, dst  []cidrRule
+	ports     map[uint16]uint8
+	direction uint16
+}
+
+func NewNetMatcher(src, dst, ports []string, direction string) (*NetMatcher, error) {
+	m := &NetMatcher{}
+	var err error
+	if m.src, err = parseCIDRRules(src); err != nil {
+		return nil, err
+	}
+	if m.dst, err = parseCIDRRules(dst); err != nil {
+		return nil, err
+	}
+	if m.ports, err = parsePortRules(ports); err != nil {
+		return nil, err
+	}
+	if m.direction, err = parseDirection(direction); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Match reports whether a connection passes; it applies alike to TCP_CONN,
+// UDP and DNS events, as the kernel filters do. Events without one always pass.
+func (m *NetMatcher) Match(c *ConnectionInfo) bool {
+	if c == nil {
+		return true
+	}
+	if m.direction != 0 && m.direction != c.Direction {
+		return false
+	}
+	return portPass(m.ports, c.DstPort) && cidrPass(m.src, c.SrcIP) && cidrPass(m.dst, c.DstIP)
+}
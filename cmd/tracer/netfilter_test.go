@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func testConn(src, dst string, dport, dir uint16) *ConnectionInfo {
+	return &ConnectionInfo{
+		SrcIP:     netip.MustParseAddr(src),
+		SrcPort:   40000,
+		DstIP:     netip.MustParseAddr(dst),
+		DstPort:   dport,
+		Direction: dir,
+	}
+}
+
+func TestNetMatcherMatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		src, dst  []string
+		ports     []string
+		direction string
+		conn      *ConnectionInfo
+		want      bool
+	}{
+		{name: "no rules", conn: testConn("10.0.0.1", "1.1.1.1", 443, TCP_DIR_OUT), want: true},
+		{name: "event without a connection", dst: []string{"10.0.0.0/8"}, conn: nil, want: true},
+
+		{name: "dst in allowed CIDR", dst: []string{"10.0.0.0/8"},
+			conn: testConn("192.168.1.2", "10.1.2.3", 443, TCP_DIR_OUT), want: true},
+		{name: "dst outside allowed CIDR", dst: []string{"10.0.0.0/8"},
+			conn: testConn("192.168.1.2", "11.0.0.1", 443, TCP_DIR_OUT), want: false},
+		{name: "bare address", dst: []string{"1.1.1.1"},
+			conn: testConn("192.168.1.2", "1.1.1.1", 53, TCP_DIR_OUT), want: true},
+		// Адреса из ядра приходят IPv4-mapped
+		{name: "IPv4-mapped address", dst: []string{"10.0.0.0/8"},
+			conn: testConn("::ffff:192.168.1.2", "::ffff:10.1.2.3", 443, TCP_DIR_OUT), want: true},
+		{name: "IPv6 CIDR", src: []string{"2001:db8::/32"},
+			conn: testConn("2001:db8::1", "2001:4860::8888", 443, TCP_DIR_OUT), want: true},
+		{name: "IPv4 rule does not match IPv6", src: []string{"0.0.0.0/0"},
+			conn: testConn("2001:db8::1", "2001:4860::8888", 443, TCP_DIR_OUT), want: false},
+		{name: "excluded CIDR", dst: []string{"!10.0.0.0/8"},
+			conn: testConn("192.168.1.2", "10.1.2.3", 443, TCP_DIR_OUT), want: false},
+		{name: "exclusions only let the rest pass", dst: []string{"!10.0.0.0/8"},
+			conn: testConn("192.168.1.2", "8.8.8.8", 443, TCP_DIR_OUT), want: true},
+		{name: "longer exclusion wins", dst: []string{"10.0.0.0/8", "!10.1.0.0/16"},
+			conn: testConn("192.168.1.2", "10.1.2.3", 443, TCP_DIR_OUT), want: false},
+		{name: "longer allow wins", dst: []string{"!10.0.0.0/8", "10.1.0.0/16"},
+			conn: testConn("192.168.1.2", "10.1.2.3", 443, TCP_DIR_OUT), want: true},
+		{name: "src and dst both apply", src: []string{"192.168.0.0/16"}, dst: []string{"10.0.0.0/8"},
+			conn: testConn("172.16.0.1", "10.1.2.3", 443, TCP_DIR_OUT), want: false},
+
+		{name: "allowed port", ports: []string{"443", "80"},
+			conn: testConn("192.168.1.2", "1.1.1.1", 80, TCP_DIR_OUT), want: true},
+		{name: "port not in the list", ports: []string{"443"},
+			conn: testConn("192.168.1.2", "1.1.1.1", 53, TCP_DIR_OUT), want: false},
+		{name: "excluded port", ports: []string{"!53"},
+			conn: testConn("192.168.1.2", "1.1.1.1", 53, TCP_DIR_OUT), want: false},
+		{name: "port exclusions only let the rest pass", ports: []string{"!53"},
+			conn: testConn("192.168.1.2", "1.1.1.1", 443, TCP_DIR_OUT), want: true},
+
+		{name: "direction matches", direction: "in",
+			conn: testConn("1.1.1.1", "192.168.1.2", 22, TCP_DIR_IN), want: true},
+		{name: "direction differs", direction: "out",
+			conn: testConn("1.1.1.1", "192.168.1.2", 22, TCP_DIR_IN), want: false},
+		{name: "all rules together", dst: []string{"10.0.0.0/8"}, ports: []string{"5432"}, direction: "outbound",
+			conn: testConn("192.168.1.2", "10.0.0.5", 5432, TCP_DIR_OUT), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewNetMatcher(tt.src, tt.dst, tt.ports, tt.direction)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(tt.conn); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNetMatcherErrors(t *testing.T) {
+	tests := []struct {
+		name            string
+		src, dst, ports []string
+		direction       string
+	}{
+		{name: "bad CIDR", dst: []string{"10.0.0.0/33"}},
+		{name: "bad address", src: []string{"not-an-ip"}},
+		{name: "bad port", ports: []string{"65536"}},
+		{name: "bad direction", direction: "sideways"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNetMatcher(tt.src, tt.dst, tt.ports, tt.direction); err == nil {
+				t.Error("NewNetMatcher succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Счётчики памяти из ядра приходят в страницах
+var pageSize = uint64(os.Getpagesize())
+
+// enum oom_constraint
+var oomConstraintNames = []string{"none", "cpuset", "memory policy", "memcg"}
+
+func oomConstraintName(c uint32) string {
+	if int(c) < len(oomConstraintNames) {
+		return oomConstraintNames[c]
+	}
+	return fmt.Sprintf("%d", c)
+}
+
+// formatBytes renders a size with a binary unit, e.g. "1.5 GiB".
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// frameName is how a frame appears in flame graphs and pprof: the function,
+// or the module it is in when the symbol is unknown.
+func frameName(f StackFrame) string {
+	switch {
+	case f.Function != "":
+		return f.Function
+	case f.Module != "":
+		return "[" + filepath.Base(f.Module) + "]"
+	}
+	return "[unknown]"
+}
+
+// ";" разделяет кадры, пробел — стек и счётчик
+var foldedEscaper = strings.NewReplacer(";", ":", " ", "_")
+
+// writeFolded writes the samples as folded stacks for flamegraph.pl:
+// "comm;outermost;...;innermost count", kernel frames marked with "_[k]".
+func writeFolded(w io.Writer, samples []ProfileSample) error {
+	lines := make(map[string]uint64)
+	for _, s := range samples {
+		parts := []string{s.Comm}
+		for i := len(s.UserStack) - 1; i >= 0; i-- {
+			parts = append(parts, frameName(s.UserStack[i]))
+		}
+		for i := len(s.KernelStack) - 1; i >= 0; i-- {
+			parts = append(parts, frameName(s.KernelStack[i])+"_[k]")
+		}
+		if len(parts) == 1 {
+			parts = append(parts, "[unknown]")
+		}
+		for i, p := range parts {
+			parts[i] = foldedEscaper.Replace(p)
+		}
+		lines[strings.Join(parts, ";")] += s.Count
+	}
+	keys := make([]string, 0, len(lines))
+	for k := range lines {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(w, "%s %d\n", k, lines[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pprofBuilder encodes profile.proto of github.com/google/pprof by hand:
+// the message is small and the protobuf module is already a dependency.
+type pprofBuilder struct {
+	strings   map[string]int64
+	table     []string
+	functions map[[2]string]uint64 // имя и файл
+	locations map[StackFrame]uint64
+	funcMsgs  []byte
+	locMsgs   []byte
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		strings:   map[string]int64{"": 0},
+		table:     []string{""},
+		functions: make(map[[2]string]uint64),
+		locations: make(map[StackFrame]uint64),
+	}
+}
+
+func (b *pprofBuilder) str(s string) int64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := int64(len(b.table))
+	b.strings[s] = i
+	b.table = append(b.table, s)
+	return i
+}
+
+func (b *pprofBuilder) function(name, file string) uint64 {
+	key := [2]string{name, file}
+	if id, ok := b.functions[key]; ok {
+		return id
+	}
+	id := uint64(len(b.functions) + 1)
+	b.functions[key] = id
+	var m []byte
+	m = appendVarintField(m, 1, id)
+	m = appendVarintField(m, 2, uint64(b.str(name)))
+	m = appendVarintField(m, 3, uint64(b.str(name)))
+	m = appendVarintField(m, 4, uint64(b.str(file)))
+	b.funcMsgs = appendBytesField(b.funcMsgs, 5, m)
+	return id
+}
+
+func (b *pprofBuilder) location(f StackFrame) uint64 {
+	if id, ok := b.locations[f]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[f] = id
+	var line []byte
+	line = appendVarintField(line, 1, b.function(frameName(f), f.File))
+	line = appendVarintField(line, 2, uint64(f.Line))
+	var m []byte
+	m = appendVarintField(m, 1, id)
+	m = appendVarintField(m, 2, 1)
+	m = appendVarintField(m, 3, f.Address)
+	m = appendBytesField(m, 4, line)
+	b.locMsgs = appendBytesField(b.locMsgs, 4, m)
+	return id
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendValueType(b []byte, num protowire.Number, typ, unit int64) []byte {
+	var m []byte
+	m = appendVarintField(m, 1, uint64(typ))
+	m = appendVarintField(m, 2, uint64(unit))
+	return appendBytesField(b, num, m)
+}
+
+// writePprof writes the samples as a gzipped pprof CPU profile with two
+// values per sample, samples/count and cpu/nanoseconds, and pid and comm
+// labels (`go tool pprof -tagfocus=comm=nginx`).
+func writePprof(w io.Writer, samples []ProfileSample, freq uint64, start time.Time, duration time.Duration) error {
+	period := int64(time.Second) / int64(freq)
+	b := newPprofBuilder()
+	var p []byte
+	p = appendValueType(p, 1, b.str("samples"), b.str("count"))
+	p = appendValueType(p, 1, b.str("cpu"), b.str("nanoseconds"))
+	for _, s := range samples {
+		// Первым идёт самый вложенный кадр: ядро над пользовательским стеком
+		var locs, values []byte
+		for _, f := range s.KernelStack {
+			locs = protowire.AppendVarint(locs, b.location(f))
+		}
+		for _, f := range s.UserStack {
+			locs = protowire.AppendVarint(locs, b.location(f))
+		}
+		if len(locs) == 0 {
+			locs = protowire.AppendVarint(locs, b.location(StackFrame{}))
+		}
+		values = protowire.AppendVarint(values, s.Count)
+		values = protowire.AppendVarint(values, s.Count*uint64(period))
+		var comm, pid, m []byte
+		comm = appendVarintField(comm, 1, uint64(b.str("comm")))
+		comm = appendVarintField(comm, 2, uint64(b.str(s.Comm)))
+		pid = appendVarintField(pid, 1, uint64(b.str("pid")))
+		pid = appendVarintField(pid, 3, uint64(s.PID))
+		m = appendBytesField(m, 1, locs)
+		m = appendBytesField(m, 2, values)
+		m = appendBytesField(m, 3, comm)
+		m = appendBytesField(m, 3, pid)
+		p = appendBytesField(p, 2, m)
+	}
+	// Одно отображение на всё с has_functions: кадры уже названы, и pprof
+	// не пытается символизировать их сам
+	var mapping []byte
+	mapping = appendVarintField(mapping, 1, 1)
+	mapping = appendVarintField(mapping, 7, 1)
+	p = appendBytesField(p, 3, mapping)
+	p = append(p, b.locMsgs...)
+	p = append(p, b.funcMsgs...)
+	p = appendVarintField(p, 9, uint64(start.UnixNano()))
+	p = appendVarintField(p, 10, uint64(duration))
+	p = appendValueType(p, 11, b.str("cpu"), b.str("nanoseconds"))
+	p = appendVarintField(p, 12, uint64(period))
+	// Таблица строк — последней: до этого места в неё добавляются имена
+	for _, s := range b.table {
+		p = protowire.AppendTag(p, 6, protowire.BytesType)
+		p = protowire.AppendString(p, s)
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(p); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Разобранный обратно profile.proto: ровно то, что пишет writePprof
+type decodedSample struct {
+	stack  []string // "функция file:line", от вершины
+	values []int64
+	labels map[string]string
+}
+
+type decodedProfile struct {
+	samples    []decodedSample
+	period     int64
+	durationNs int64
+	timeNs     int64
+	sampleType []string
+}
+
+// decodeFields splits a message into its fields, keeping repeated ones in order.
+func decodeFields(t *testing.T, b []byte) map[protowire.Number][]any {
+	t.Helper()
+	out := make(map[protowire.Number][]any)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("bad varint in field %d", num)
+			}
+			out[num] = append(out[num], v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("bad bytes in field %d", num)
+			}
+			out[num] = append(out[num], v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d in field %d", typ, num)
+		}
+	}
+	return out
+}
+
+func varint(f map[protowire.Number][]any, num protowire.Number) uint64 {
+	if len(f[num]) == 0 {
+		return 0
+	}
+	return f[num][0].(uint64)
+}
+
+func packed(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	var out []uint64
+	for len(b) > 0 {
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			t.Fatal("bad packed varint")
+		}
+		out = append(out, v)
+		b = b[n:]
+	}
+	return out
+}
+
+func decodePprof(t *testing.T, data []byte) decodedProfile {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := decodeFields(t, raw)
+
+	var table []string
+	for _, s := range p[6] {
+		table = append(table, string(s.([]byte)))
+	}
+	if len(table) == 0 || table[0] != "" {
+		t.Fatalf("string table must start with \"\": %q", table)
+	}
+	str := func(i uint64) string {
+		if i >= uint64(len(table)) {
+			t.Fatalf("string index %d out of range", i)
+		}
+		return table[i]
+	}
+
+	functions := make(map[uint64]string)
+	for _, m := range p[5] {
+		f := decodeFields(t, m.([]byte))
+		functions[varint(f, 1)] = str(varint(f, 2)) + " " + str(varint(f, 4))
+	}
+	locations := make(map[uint64]string)
+	for _, m := range p[4] {
+		l := decodeFields(t, m.([]byte))
+		if varint(l, 2) != 1 {
+			t.Errorf("location %d: mapping_id = %d, want 1", varint(l, 1), varint(l, 2))
+		}
+		line := decodeFields(t, l[4][0].([]byte))
+		name, ok := functions[varint(line, 1)]
+		if !ok {
+			t.Fatalf("location %d refers to unknown function %d", varint(l, 1), varint(line, 1))
+		}
+		locations[varint(l, 1)] = strings.TrimSuffix(name, " ") + ":" + strconv.FormatUint(varint(line, 2), 10)
+	}
+
+	var out decodedProfile
+	for _, m := range p[1] {
+		vt := decodeFields(t, m.([]byte))
+		out.sampleType = append(out.sampleType, str(varint(vt, 1))+"/"+str(varint(vt, 2)))
+	}
+	for _, m := range p[2] {
+		s := decodeFields(t, m.([]byte))
+		var d decodedSample
+		for _, id := range packed(t, s[1][0].([]byte)) {
+			loc, ok := locations[id]
+			if !ok {
+				t.Fatalf("sample refers to unknown location %d", id)
+			}
+			d.stack = append(d.stack, loc)
+		}
+		for _, v := range packed(t, s[2][0].([]byte)) {
+			d.values = append(d.values, int64(v))
+		}
+		d.labels = make(map[string]string)
+		for _, lb := range s[3] {
+			l := decodeFields(t, lb.([]byte))
+			if v := varint(l, 2); v != 0 {
+				d.labels[str(varint(l, 1))] = str(v)
+			} else {
+				d.labels[str(varint(l, 1))] = strconv.FormatUint(varint(l, 3), 10)
+			}
+		}
+		out.samples = append(out.samples, d)
+	}
+	out.timeNs = int64(varint(p, 9))
+	out.durationNs = int64(varint(p, 10))
+	out.period = int64(varint(p, 12))
+	return out
+}
+
+func TestWritePprofRoundTrip(t *testing.T) {
+	read := StackFrame{Address: 0xffffffff81000010, Function: "ksys_read", Module: "kernel"}
+	app := StackFrame{Address: 0x401000, Function: "main", Module: "/usr/bin/app", File: "main.c", Line: 12}
+	anon := StackFrame{Address: 0x7f0000001000}
+
+	tests := []struct {
+		name    string
+		samples []ProfileSample
+		want    []decodedSample
+	}{
+		{
+			name: "kernel over user frames",
+			samples: []ProfileSample{
+				{PID: 42, Comm: "app", KernelStack: []StackFrame{read}, UserStack: []StackFrame{app}, Count: 3},
+			},
+			want: []decodedSample{
+				{stack: []string{"ksys_read:0", "main main.c:12"}, values: []int64{3, 3 * 10101010},
+					labels: map[string]string{"comm": "app", "pid": "42"}},
+			},
+		},
+		{
+			name: "frames shared between samples",
+			samples: []ProfileSample{
+				{PID: 1, Comm: "a", UserStack: []StackFrame{app}, Count: 1},
+				{PID: 2, Comm: "b", KernelStack: []StackFrame{read}, UserStack: []StackFrame{app}, Count: 2},
+			},
+			want: []decodedSample{
+				{stack: []string{"main main.c:12"}, values: []int64{1, 10101010},
+					labels: map[string]string{"comm": "a", "pid": "1"}},
+				{stack: []string{"ksys_read:0", "main main.c:12"}, values: []int64{2, 2 * 10101010},
+					labels: map[string]string{"comm": "b", "pid": "2"}},
+			},
+		},
+		{
+			name: "unknown symbol and empty stack",
+			samples: []ProfileSample{
+				{PID: 7, Comm: "jit", UserStack: []StackFrame{anon}, Count: 1},
+				{PID: 8, Comm: "idle", Count: 5},
+			},
+			want: []decodedSample{
+				{stack: []string{"[unknown]:0"}, values: []int64{1, 10101010},
+					labels: map[string]string{"comm": "jit", "pid": "7"}},
+				{stack: []string{"[unknown]:0"}, values: []int64{5, 5 * 10101010},
+					labels: map[string]string{"comm": "idle", "pid": "8"}},
+			},
+		},
+	}
+	start := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writePprof(&buf, tt.samples, 99, start, 30*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			got := decodePprof(t, buf.Bytes())
+			if !reflect.DeepEqual(got.samples, tt.want) {
+				t.Errorf("samples = %+v, want %+v", got.samples, tt.want)
+			}
+			if want := []string{"samples/count", "cpu/nanoseconds"}; !reflect.DeepEqual(got.sampleType, want) {
+				t.Errorf("sample types = %q, want %q", got.sampleType, want)
+			}
+			if got.period != 10101010 || got.durationNs != int64(30*time.Second) || got.timeNs != start.UnixNano() {
+				t.Errorf("period %d, duration %d, time %d", got.period, got.durationNs, got.timeNs)
+			}
+		})
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	outer := StackFrame{Function: "main"}
+	inner := StackFrame{Function: "do work"}
+	tests := []struct {
+		name    string
+		samples []ProfileSample
+		want    string
+	}{
+		{
+			name: "outermost frame first, kernel marked",
+			samples: []ProfileSample{
+				{Comm: "app", UserStack: []StackFrame{inner, outer}, KernelStack: []StackFrame{{Function: "ksys_read"}}, Count: 2},
+			},
+			want: "app;main;do_work;ksys_read_[k] 2\n",
+		},
+		{
+			name: "equal stacks are summed",
+			samples: []ProfileSample{
+				{Comm: "app", UserStack: []StackFrame{outer}, Count: 2},
+				{Comm: "app", UserStack: []StackFrame{outer}, Count: 3},
+				{Comm: "b;c", Count: 1},
+			},
+			want: "app;main 5\nb:c;[unknown] 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf strings.Builder
+			if err := writeFolded(&buf, tt.samples); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("writeFolded = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
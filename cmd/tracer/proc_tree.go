@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Сколько держим вышедший процесс в дереве: события, пришедшие чуть позже
+// EXIT, и его дети должны успеть увидеть родителя
+const processTreeLinger = 10 * time.Second
+
+// Глубже не ходим — защита от циклов при переиспользовании PID
+const maxAncestryDepth = 64
+
+// ProcessNode is one process in the tree.
+type ProcessNode struct {
+	PID      uint32
+	PPID     uint32
+	Comm     string
+	Exe      string // последний execve; пусто, если процесс не делал exec на наших глазах
+	Started  time.Time
+	Exited   bool
+	exitedAt time.Time
+}
+
+// ProcessTree is a live view of the process hierarchy, seeded from /proc at
+// startup and maintained from CLONE, EXECVE and EXIT events.
+type ProcessTree struct {
+	mu     sync.Mutex
+	nodes  map[uint32]*ProcessNode
+	exited []uint32 // в порядке выхода, для отложенного удаления
+}
+
+func NewProcessTree() *ProcessTree {
+	t := &ProcessTree{nodes: make(map[uint32]*ProcessNode)}
+	t.seed()
+	return t
+}
+
+// seed reads every process currently in /proc.
+func (t *ProcessTree) seed() {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		if n := readProcNode(uint32(pid)); n != nil {
+			t.nodes[n.PID] = n
+		}
+	}
+}
+
+// readProcNode builds a node from /proc/<pid>/stat and /proc/<pid>/exe.
+func readProcNode(pid uint32) *ProcessNode {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil
+	}
+	// pid (comm) state ppid ... — comm может содержать пробелы и скобки
+	s := string(data)
+	open, closing := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || closing < open {
+		return nil
+	}
+	fields := strings.Fields(s[closing+1:])
+	if len(fields) < 2 {
+		return nil
+	}
+	ppid, _ := strconv.ParseUint(fields[1], 10, 32)
+	exe, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	return &ProcessNode{PID: pid, PPID: uint32(ppid), Comm: s[open+1 : closing], Exe: exe}
+}
+
+// lookup returns the node for pid, reading /proc for processes not seen yet.
+// Must be called with t.mu held.
+func (t *ProcessTree) lookup(pid uint32) *ProcessNode {
+	if n, ok := t.nodes[pid]; ok {
+		return n
+	}
+	n := readProcNode(pid)
+	if n != nil {
+		t.nodes[pid] = n
+	}
+	return n
+}
+
+// Forked records a new process (threads are not part of the tree).
+func (t *ProcessTree) Forked(parent, child uint32, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.nodes[child]; ok && old.Exited {
+		t.reparent(child)
+	}
+	n := &ProcessNode{PID: child, PPID: parent, Started: at}
+	if p := t.lookup(parent); p != nil {
+		// До exec ребёнок — копия родителя
+		n.Comm, n.Exe = p.Comm, p.Exe
+	}
+	t.nodes[child] = n
+}
+
+// reparent detaches the children of an exited process whose PID was just
+// reused: they belong to the old process, not to the new one. The kernel has
+// moved them to init or a subreaper already, so the parent is re-read from
+// /proc; a child that is gone too keeps no parent. Must be called with t.mu held.
+func (t *ProcessTree) reparent(pid uint32) {
+	for _, n := range t.nodes {
+		if n.PPID != pid || n.PID == pid {
+			continue
+		}
+		n.PPID = 0
+		if fresh := readProcNode(n.PID); fresh != nil {
+			n.PPID = fresh.PPID
+		}
+	}
+}
+
+// Execed updates the program a process runs.
+func (t *ProcessTree) Execed(pid, ppid uint32, comm, exe string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n, ok := t.nodes[pid]
+	if !ok {
+		n = &ProcessNode{PID: pid}
+		t.nodes[pid] = n
+	}
+	n.PPID, n.Comm, n.Exe = ppid, comm, exe
+}
+
+// Exited marks a process as gone; it is dropped after processTreeLinger.
+func (t *ProcessTree) Exited(pid uint32, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(at)
+	if n, ok := t.nodes[pid]; ok && !n.Exited {
+		n.Exited, n.exitedAt = true, at
+		t.exited = append(t.exited, pid)
+	}
+}
+
+func (t *ProcessTree) prune(now time.Time) {
+	i := 0
+	for ; i < len(t.exited); i++ {
+		n, ok := t.nodes[t.exited[i]]
+		if ok && now.Sub(n.exitedAt) < processTreeLinger {
+			break
+		}
+		// PID мог быть переиспользован новым процессом — такой не трогаем
+		if ok && n.Exited {
+			delete(t.nodes, t.exited[i])
+		}
+	}
+	t.exited = t.exited[i:]
+}
+
+// Ancestry describes pid and its ancestors, e.g. "bash(100) > sshd(99) > systemd(1)".
+func (t *ProcessTree) Ancestry(pid uint32) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var parts []string
+	for depth := 0; pid != 0 && depth < maxAncestryDepth; depth++ {
+		n := t.lookup(pid)
+		if n == nil {
+			parts = append(parts, fmt.Sprintf("?(%d)", pid))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s(%d)", n.Comm, n.PID))
+		if n.PPID == n.PID {
+			break
+		}
+		pid = n.PPID
+	}
+	return strings.Join(parts, " > ")
+}
+
+// Comm returns the last known name of pid, or "".
+func (t *ProcessTree) Comm(pid uint32) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := t.lookup(pid); n != nil {
+		return n.Comm
+	}
+	return ""
+}
+
+// Snapshot returns the live processes, or only root and its descendants if
+// root is not 0, sorted by PID.
+func (t *ProcessTree) Snapshot(root uint32) []ProcessNode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(time.Now())
+
+	var out []ProcessNode
+	if root == 0 {
+		for _, n := range t.nodes {
+			out = append(out, *n)
+		}
+	} else {
+		children := make(map[uint32][]uint32)
+		for _, n := range t.nodes {
+			if n.PID != n.PPID {
+				children[n.PPID] = append(children[n.PPID], n.PID)
+			}
+		}
+		queue := []uint32{root}
+		seen := make(map[uint32]bool)
+		for len(queue) > 0 {
+			pid := queue[0]
+			queue = queue[1:]
+			n, ok := t.nodes[pid]
+			if !ok || seen[pid] {
+				continue
+			}
+			seen[pid] = true
+			out = append(out, *n)
+			queue = append(queue, children[pid]...)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PID < out[j].PID })
+	return out
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// PID больше pid_max: lookup не найдёт их в /proc
+const testPID = 5000000
+
+func TestProcessTreeAncestryPIDReuse(t *testing.T) {
+	var (
+		initPID = uint32(testPID)
+		shell   = uint32(testPID + 1)
+		child   = uint32(testPID + 2)
+		sshd    = uint32(testPID + 3)
+		orphan  = uint32(testPID + 4)
+	)
+	start := time.Unix(1000, 0)
+
+	type step func(tr *ProcessTree)
+	tests := []struct {
+		name  string
+		steps []step
+		pid   uint32
+		want  string
+	}{
+		{
+			name: "plain fork and exec",
+			steps: []step{
+				func(tr *ProcessTree) { tr.Forked(shell, child, start) },
+				func(tr *ProcessTree) { tr.Execed(child, shell, "ls", "/bin/ls") },
+			},
+			pid:  child,
+			want: "ls(5000002) > bash(5000001) > init(5000000)",
+		},
+		{
+			name: "forked child keeps the parent's name until exec",
+			steps: []step{
+				func(tr *ProcessTree) { tr.Forked(shell, child, start) },
+			},
+			pid:  child,
+			want: "bash(5000002) > bash(5000001) > init(5000000)",
+		},
+		{
+			name: "exited process lingers",
+			steps: []step{
+				func(tr *ProcessTree) { tr.Forked(shell, child, start) },
+				func(tr *ProcessTree) { tr.Execed(child, shell, "ls", "/bin/ls") },
+				func(tr *ProcessTree) { tr.Exited(child, start.Add(time.Second)) },
+			},
+			pid:  child,
+			want: "ls(5000002) > bash(5000001) > init(5000000)",
+		},
+		{
+			name: "reused PID while the old process lingers",
+			steps: []step{
+				func(tr *ProcessTree) { tr.Forked(shell, child, start) },
+				func(tr *ProcessTree) { tr.Execed(child, shell, "ls", "/bin/ls") },
+				func(tr *ProcessTree) { tr.Exited(child, start.Add(time.Second)) },
+				func(tr *ProcessTree) { tr.Forked(sshd, child, start.Add(2*time.Second)) },
+				func(tr *ProcessTree) { tr.Execed(child, sshd, "sh", "/bin/sh") },
+			},
+			pid:  child,
+			want: "sh(5000002) > sshd(5000003) > init(5000000)",
+		},
+		{
+			name: "reused PID after the old process was pruned",
+			steps: []step{
+				func(tr *ProcessTree) { tr.Forked(shell, child, start) },
+				func(tr *ProcessTree) { tr.Exited(child, start) },
+				// Любой следующий выход чистит задержавшиеся узлы
+				func(tr *ProcessTree) { tr.Exited(orphan, start.Add(processTreeLinger+time.Second)) },
+				func(tr *ProcessTree) { tr.Forked(sshd, child, start.Add(processTreeLinger+2*time.Second)) },
+			},
+			pid:  child,
+			want: "sshd(5000002) > sshd(5000003) > init(5000000)",
+		},
+		{
+			name: "children of the old process are not children of the new one",
+			steps: []step{
+				func(tr *ProcessTree) { tr.Forked(shell, child, start) },
+				func(tr *ProcessTree) { tr.Forked(child, orphan, start) },
+				func(tr *ProcessTree) { tr.Exited(child, start.Add(time.Second)) },
+				func(tr *ProcessTree) { tr.Forked(sshd, child, start.Add(2*time.Second)) },
+			},
+			pid:  orphan,
+			want: "bash(5000004)",
+		},
+		{
+			name: "process unknown to the tree and to /proc",
+			pid:  testPID + 100,
+			want: "?(5000100)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &ProcessTree{nodes: map[uint32]*ProcessNode{
+				initPID: {PID: initPID, PPID: 0, Comm: "init"},
+				shell:   {PID: shell, PPID: initPID, Comm: "bash", Exe: "/bin/bash"},
+				sshd:    {PID: sshd, PPID: initPID, Comm: "sshd", Exe: "/usr/sbin/sshd"},
+			}}
+			for _, s := range tt.steps {
+				s(tr)
+			}
+			if got := tr.Ancestry(tt.pid); got != tt.want {
+				t.Errorf("Ancestry(%d) = %q, want %q", tt.pid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessTreeSnapshotSubtree(t *testing.T) {
+	tr := &ProcessTree{nodes: make(map[uint32]*ProcessNode)}
+	tr.nodes[testPID] = &ProcessNode{PID: testPID, Comm: "init"}
+	tr.Forked(testPID, testPID+1, time.Now())
+	tr.Forked(testPID+1, testPID+2, time.Now())
+	tr.Forked(testPID, testPID+3, time.Now())
+
+	got := tr.Snapshot(testPID + 1)
+	if len(got) != 2 || got[0].PID != testPID+1 || got[1].PID != testPID+2 {
+		t.Errorf("Snapshot = %+v, want %d and %d", got, testPID+1, testPID+2)
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+    "bytes"
     "encoding/binary"
+    "encoding/hex"
     "fmt"
-    "net"
+    "net/netip"
     "os"
+    "path/filepath"
     "strings"
+    "sync/atomic"
     "time"
     "unicode/utf8"
+
+    "golang.org/x/sys/unix"
 )
 
 const (
@@ -21,13 +27,65 @@ const (
     EVENT_TYPE_EXIT     = 8
     EVENT_TYPE_TCP_CONN = 9
     EVENT_TYPE_UPROBE   = 10
+    EVENT_TYPE_CLOSE    = 11
+    EVENT_TYPE_DATA     = 12
+    EVENT_TYPE_UNLINK   = 13
+    EVENT_TYPE_RENAME   = 14
+    EVENT_TYPE_CHMOD    = 15
+    EVENT_TYPE_CHOWN    = 16
+    EVENT_TYPE_MKDIR    = 17
+    EVENT_TYPE_RMDIR    = 18
+    EVENT_TYPE_TRUNCATE = 19
+    EVENT_TYPE_UDP      = 20
+    EVENT_TYPE_DNS      = 21
+    EVENT_TYPE_SIGNAL   = 22
+    EVENT_TYPE_CRED     = 23
+    EVENT_TYPE_CAPABLE  = 24
+    EVENT_TYPE_MODULE   = 25
+    EVENT_TYPE_BPF      = 26
+    EVENT_TYPE_MMAP_EXEC = 27
+    EVENT_TYPE_NAMESPACE = 28
+    EVENT_TYPE_MOUNT     = 29
+    EVENT_TYPE_OOM       = 30
+    EVENT_TYPE_SYSCALL   = 31
+    EVENT_TYPE_BLOCK_IO  = 32
+
+    // Бит в type: CLONE/EXECVE/EXIT не прошёл фильтры и нужен только дереву
+    EVENT_FLAG_HIDDEN = 1 << 31
 )
 
+// Имена файловых операций для поля Type
+var fileOpNames = map[uint32]string{
+    EVENT_TYPE_UNLINK:   "UNLINK",
+    EVENT_TYPE_RENAME:   "RENAME",
+    EVENT_TYPE_CHMOD:    "CHMOD",
+    EVENT_TYPE_CHOWN:    "CHOWN",
+    EVENT_TYPE_MKDIR:    "MKDIR",
+    EVENT_TYPE_RMDIR:    "RMDIR",
+    EVENT_TYPE_TRUNCATE: "TRUNCATE",
+}
+
+// dfd, означающий текущий каталог процесса
+const atFDCWD = -100
+
+// Сколько байт payload показывать в Details (полностью они уходят в ProcessedEvent.Data)
+const payloadPreviewBytes = 64
+
 type Processor struct {
     filterPID uint32
     sampling  int
     count     int
     myPID     uint32 // наш собственный PID, вычисляется один раз
+    emitted   atomic.Uint64
+    cgroups   *CgroupResolver
+    users     *UserResolver
+    fds       *FDResolver
+    tree      *ProcessTree
+    dns       *DNSCache
+    kills     *KillTracker
+    stacks    *Symbolizer            // nil без --stacks
+    hidden    uint32                 // типы не из --events, нужные только trackLifecycle
+    pathPass  func(path string) bool // --path для относительных путей; nil — без проверки
 }
 
 func sanitizeUTF8(s string) string {
@@ -52,6 +110,12 @@ func NewProcessor(pidFilter uint32, samplingRate int) *Processor {
         filterPID: pidFilter,
         sampling:  samplingRate,
         myPID:     uint32(os.Getpid()),
+        cgroups:   NewCgroupResolver(),
+        users:     NewUserResolver(),
+        fds:       NewFDResolver(),
+        tree:      NewProcessTree(),
+        dns:       NewDNSCache(),
+        kills:     NewKillTracker(),
     }
 }
 
@@ -61,86 +125,701 @@ func (p *Processor) Start(in <-chan EventRaw, out chan<- *ProcessedEvent) {
         if event.PID == p.myPID {
             continue
         }
-        // Опциональный фильтр по pid
-        if p.filterPID != 0 && event.PID != p.filterPID {
-            continue
+        // Дерево процессов ведём до сэмплирования, иначе в нём будут дыры
+        p.trackLifecycle(event)
+        if processed := p.filterAndProcess(event); processed != nil {
+            p.emitted.Add(1)
+            out <- processed
         }
-        p.count++
-        if p.sampling > 1 && p.count%p.sampling != 0 {
-            continue
+        // Закрытые fd забываем после разбора: CLOSE ещё показывает, что закрыто
+        p.forgetFDs(event)
+    }
+}
+
+func (p *Processor) filterAndProcess(event EventRaw) *ProcessedEvent {
+    if event.Hidden || p.hidden&(1<<(event.Type-1)) != 0 {
+        return nil
+    }
+    // Опциональный фильтр по pid
+    if p.filterPID != 0 && event.PID != p.filterPID {
+        return nil
+    }
+    p.count++
+    if p.sampling > 1 && p.count%p.sampling != 0 {
+        return nil
+    }
+    return p.processEvent(event)
+}
+
+// forgetFDs drops cached descriptors that CLOSE, dup2/dup3, exec and the
+// process' exit invalidate; like trackLifecycle, it sees every event.
+func (p *Processor) forgetFDs(event EventRaw) {
+    switch event.Type {
+    case EVENT_TYPE_CLOSE:
+        p.fds.Closed(event.PID, int32(binary.LittleEndian.Uint32(event.Data[:4])))
+    case EVENT_TYPE_EXECVE:
+        // После exec таблица fd могла измениться (O_CLOEXEC)
+        p.fds.Exited(event.PID)
+    case EVENT_TYPE_EXIT:
+        if binary.LittleEndian.Uint32(event.Data[20:24]) == 0 {
+            p.fds.Exited(event.PID)
         }
-        processed := p.processEvent(event)
-        if processed != nil {
-            out <- processed
+    }
+}
+
+// trackLifecycle feeds CLONE, EXECVE and EXIT into the process tree,
+// descriptors from OPEN and CONNECT into the fd cache and DNS answers into
+// the DNS cache.
+func (p *Processor) trackLifecycle(event EventRaw) {
+    switch event.Type {
+    case EVENT_TYPE_OPEN:
+        fd := int32(binary.LittleEndian.Uint32(event.Data[260:264]))
+        dfd := int32(binary.LittleEndian.Uint32(event.Data[264:268]))
+        p.fds.Opened(event.PID, fd, p.resolveAtPath(event.PID, dfd, cString(event.Data[:256])))
+    case EVENT_TYPE_CONNECT:
+        // После connect у сокета появился удалённый адрес — перечитаем при разборе
+        p.fds.Closed(event.PID, int32(binary.LittleEndian.Uint32(event.Data[:4])))
+    case EVENT_TYPE_CLONE:
+        if binary.LittleEndian.Uint32(event.Data[12:16]) != 0 {
+            return // поток
+        }
+        p.tree.Forked(binary.LittleEndian.Uint32(event.Data[0:4]),
+            binary.LittleEndian.Uint32(event.Data[4:8]), time.Now())
+    case EVENT_TYPE_EXECVE:
+        if p.stacks != nil {
+            p.stacks.Forget(event.PID)
+        }
+        p.tree.Execed(event.PID, binary.LittleEndian.Uint32(event.Data[260:264]),
+            strings.TrimRight(string(event.Comm[:]), "\x00"),
+            strings.TrimRight(string(event.Data[:256]), "\x00"))
+    case EVENT_TYPE_EXIT:
+        if binary.LittleEndian.Uint32(event.Data[20:24]) != 0 {
+            return
+        }
+        p.tree.Exited(event.PID, time.Now())
+        p.dns.Forget(event.PID)
+        if p.stacks != nil {
+            p.stacks.Forget(event.PID)
+        }
+    case EVENT_TYPE_SIGNAL:
+        // Кто кого убил — запоминаем до сэмплирования, чтобы подписать EXIT
+        if binary.LittleEndian.Uint32(event.Data[20:24]) != SIGNAL_GENERATE {
+            return
+        }
+        p.kills.Sent(binary.LittleEndian.Uint32(event.Data[0:4]),
+            int32(binary.LittleEndian.Uint32(event.Data[8:12])),
+            int32(binary.LittleEndian.Uint32(event.Data[12:16])),
+            event.PID, strings.TrimRight(string(event.Comm[:]), "\x00"), time.Now())
+    case EVENT_TYPE_OOM:
+        p.kills.OOMKilled(binary.LittleEndian.Uint32(event.Data[0:4]),
+            event.PID, strings.TrimRight(string(event.Comm[:]), "\x00"), time.Now())
+    case EVENT_TYPE_DNS:
+        // Ответ запоминаем и для несэмплированных процессов: по нему
+        // подписываются соединения того же процесса
+        if info, err := parseDNS(event.Payload); err == nil && info.Response {
+            p.dns.Add(event.PID, info, time.Now())
         }
     }
 }
 
+// Emitted returns how many events have been passed downstream so far.
+func (p *Processor) Emitted() uint64 {
+    return p.emitted.Load()
+}
+
 func (p *Processor) processEvent(event EventRaw) *ProcessedEvent {
     processed := &ProcessedEvent{
-        PID:       event.PID,
-        Comm:      sanitizeUTF8(strings.TrimRight(string(event.Comm[:]), "\x00")),
+        PID:        event.PID,
+        TID:        event.TID,
+        NsPID:      event.NsPID,
+        Comm:       sanitizeUTF8(strings.TrimRight(string(event.Comm[:]), "\x00")),
+        ThreadName: sanitizeUTF8(strings.TrimRight(string(event.ThreadComm[:]), "\x00")),
         // Корректно: используем время ядра (ns -> time.Time)
-        Timestamp: time.Now(), 
+        Timestamp:  time.Now(), 
+        CgroupID:   event.CgroupID,
+        UID:        event.UID,
+        GID:        event.GID,
+        User:       p.users.Name(event.UID),
+    }
+    processed.CgroupPath, processed.ContainerID = p.cgroups.Resolve(event.CgroupID)
+    processed.Ancestry = sanitizeUTF8(p.tree.Ancestry(event.PID))
+    if p.stacks != nil {
+        processed.UserStack = p.stacks.User(event.PID, event.UserStackID)
+        processed.KernelStack = p.stacks.Kernel(event.KernelStackID)
     }
 
     switch event.Type {
     case EVENT_TYPE_EXECVE:
+        filename := strings.TrimRight(string(event.Data[:256]), "\x00")
+        info := &ProcessInfo{
+            OldPID:    binary.LittleEndian.Uint32(event.Data[256:260]),
+            ParentPID: binary.LittleEndian.Uint32(event.Data[260:264]),
+            EUID:      binary.LittleEndian.Uint32(event.Data[264:268]),
+            EGID:      binary.LittleEndian.Uint32(event.Data[268:272]),
+        }
+        // Относительный путь ядро не фильтрует по --path: проверяем разрешённый
+        if p.pathPass != nil && !strings.HasPrefix(filename, "/") &&
+            !p.pathPass(p.resolveAtPath(event.PID, atFDCWD, filename)) {
+            return nil
+        }
+        info.Setuid = info.EUID != event.UID
+        info.Setgid = info.EGID != event.GID
         processed.Type = "EXECVE"
-        processed.Details = fmt.Sprintf("File: %s",
-            strings.TrimRight(string(event.Data[:256]), "\x00"))
+        processed.Process = info
+        processed.Details = fmt.Sprintf("File: %s, PPID: %d", filename, info.ParentPID)
+        if info.OldPID != event.PID {
+            // exec из не-лидера: поток занял PID лидера, остальные потоки убиты
+            processed.Details += fmt.Sprintf(", Old TID: %d", info.OldPID)
+        }
+        if info.Setuid {
+            processed.Details += fmt.Sprintf(", SETUID (euid %d)", info.EUID)
+        }
+        if info.Setgid {
+            processed.Details += fmt.Sprintf(", SETGID (egid %d)", info.EGID)
+        }
 
     case EVENT_TYPE_OPEN:
-        if len(event.Data) < 260 {
+        if len(event.Data) < 264 {
             return nil
         }
         filename := strings.TrimRight(string(event.Data[:256]), "\x00")
         flags := binary.LittleEndian.Uint32(event.Data[256:260])
+        fd := int32(binary.LittleEndian.Uint32(event.Data[260:264]))
         processed.Type = "OPEN"
-        processed.Details = fmt.Sprintf("File: %s, Flags: %d", filename, flags)
+        processed.FDTarget = filename
+        if target := p.fds.Resolve(event.PID, fd); target != "" {
+            processed.FDTarget = target // относительный путь уже разрешён в trackLifecycle
+        }
+        if p.pathPass != nil && !strings.HasPrefix(filename, "/") && !p.pathPass(processed.FDTarget) {
+            return nil
+        }
+        processed.Details = fmt.Sprintf("File: %s, Flags: %d, FD: %d", filename, flags, fd)
 
-    case EVENT_TYPE_READ, EVENT_TYPE_WRITE, EVENT_TYPE_ACCEPT, EVENT_TYPE_CONNECT:
-        if len(event.Data) < 12 {
+    case EVENT_TYPE_READ, EVENT_TYPE_WRITE, EVENT_TYPE_ACCEPT, EVENT_TYPE_CONNECT, EVENT_TYPE_CLOSE:
+        if len(event.Data) < 16 {
             return nil
         }
         fd := int32(binary.LittleEndian.Uint32(event.Data[:4]))
-        count := binary.LittleEndian.Uint64(event.Data[4:12])
-        var action string
+        ret := int32(binary.LittleEndian.Uint32(event.Data[4:8]))
+        count := binary.LittleEndian.Uint64(event.Data[8:16])
+        processed.FDTarget = p.fds.Resolve(event.PID, fd)
+        fdDesc := fmt.Sprintf("%d", fd)
+        if processed.FDTarget != "" {
+            fdDesc = fmt.Sprintf("%d (%s)", fd, processed.FDTarget)
+        }
         switch event.Type {
         case EVENT_TYPE_READ:
-            action = "READ"
+            processed.Type = "READ"
+            processed.Details = fmt.Sprintf("FD: %s, Count: %d", fdDesc, count)
         case EVENT_TYPE_WRITE:
-            action = "WRITE"
+            processed.Type = "WRITE"
+            processed.Details = fmt.Sprintf("FD: %s, Count: %d", fdDesc, count)
         case EVENT_TYPE_ACCEPT:
-            action = "ACCEPT"
+            processed.Type = "ACCEPT"
+            if ret < 0 {
+                processed.Details = fmt.Sprintf("FD: %s, Error: %d", fdDesc, ret)
+                break
+            }
+            processed.Details = fmt.Sprintf("FD: %s, New FD: %d", fdDesc, ret)
+            if conn := p.fds.Resolve(event.PID, ret); conn != "" {
+                processed.Details += fmt.Sprintf(" (%s)", conn)
+            }
         case EVENT_TYPE_CONNECT:
-            action = "CONNECT"
+            processed.Type = "CONNECT"
+            processed.Details = fmt.Sprintf("FD: %s, Result: %d", fdDesc, ret)
+        case EVENT_TYPE_CLOSE:
+            processed.Type = "CLOSE"
+            processed.Details = fmt.Sprintf("FD: %s", fdDesc)
+            if count == 1 {
+                processed.Details += fmt.Sprintf(", replaced by dup of FD %d", ret)
+            }
         }
-        processed.Type = action
-        processed.Details = fmt.Sprintf("FD: %d, Count: %d", fd, count)
+
+    case EVENT_TYPE_DATA:
+        if len(event.Data) < 20 {
+            return nil
+        }
+        fd := int32(binary.LittleEndian.Uint32(event.Data[0:4]))
+        dir := binary.LittleEndian.Uint32(event.Data[4:8])
+        ret := int64(binary.LittleEndian.Uint64(event.Data[8:16]))
+        n := int(binary.LittleEndian.Uint32(event.Data[16:20]))
+        if n > len(event.Payload) {
+            n = len(event.Payload)
+        }
+        processed.Type = "DATA"
+        processed.Data = event.Payload[:n]
+        processed.FDTarget = p.fds.Resolve(event.PID, fd)
+        action := "READ"
+        if dir == 1 {
+            action = "WRITE"
+        }
+        fdDesc := fmt.Sprintf("%d", fd)
+        if processed.FDTarget != "" {
+            fdDesc = fmt.Sprintf("%d (%s)", fd, processed.FDTarget)
+        }
+        processed.Details = fmt.Sprintf("%s FD: %s, %d of %d bytes, %s",
+            action, fdDesc, n, ret, renderPayload(processed.Data, payloadPreviewBytes))
+
+    case EVENT_TYPE_UNLINK, EVENT_TYPE_RENAME, EVENT_TYPE_CHMOD, EVENT_TYPE_CHOWN,
+        EVENT_TYPE_MKDIR, EVENT_TYPE_RMDIR, EVENT_TYPE_TRUNCATE:
+        if len(event.Data) < 296 {
+            return nil
+        }
+        op := &FileOpInfo{
+            Size:   int64(binary.LittleEndian.Uint64(event.Data[256:264])),
+            Result: int32(binary.LittleEndian.Uint32(event.Data[264:268])),
+            Flags:  int32(binary.LittleEndian.Uint32(event.Data[268:272])),
+            Mode:   binary.LittleEndian.Uint32(event.Data[272:276]),
+            UID:    int32(binary.LittleEndian.Uint32(event.Data[276:280])),
+            GID:    int32(binary.LittleEndian.Uint32(event.Data[280:284])),
+        }
+        dfd := int32(binary.LittleEndian.Uint32(event.Data[284:288]))
+        dfd2 := int32(binary.LittleEndian.Uint32(event.Data[288:292]))
+        fd := int32(binary.LittleEndian.Uint32(event.Data[292:296]))
+        path := cString(event.Data[:256])
+        if event.Type == EVENT_TYPE_TRUNCATE && path == "" {
+            // ftruncate: пути нет, только fd
+            path = p.fds.Resolve(event.PID, fd)
+            processed.FDTarget = path
+        } else {
+            path = p.resolveAtPath(event.PID, dfd, path)
+        }
+        op.Path = path
+
+        processed.Type = fileOpNames[event.Type]
+        processed.FileOp = op
+        switch event.Type {
+        case EVENT_TYPE_RENAME:
+            op.NewPath = p.resolveAtPath(event.PID, dfd2, cString(event.Payload))
+            processed.Details = fmt.Sprintf("From: %s, To: %s", op.Path, op.NewPath)
+        case EVENT_TYPE_CHMOD, EVENT_TYPE_MKDIR:
+            processed.Details = fmt.Sprintf("Path: %s, Mode: %#o", op.Path, op.Mode)
+        case EVENT_TYPE_CHOWN:
+            processed.Details = fmt.Sprintf("Path: %s, UID: %d, GID: %d", op.Path, op.UID, op.GID)
+        case EVENT_TYPE_TRUNCATE:
+            if path == "" {
+                processed.Details = fmt.Sprintf("FD: %d, Size: %d", fd, op.Size)
+            } else {
+                processed.Details = fmt.Sprintf("Path: %s, Size: %d", op.Path, op.Size)
+            }
+        default:
+            processed.Details = fmt.Sprintf("Path: %s", op.Path)
+        }
+        processed.Details += fmt.Sprintf(", Result: %d", op.Result)
 
     case EVENT_TYPE_CLONE:
+        info := &ProcessInfo{
+            ParentPID: binary.LittleEndian.Uint32(event.Data[0:4]),
+            ChildPID:  binary.LittleEndian.Uint32(event.Data[4:8]),
+            ChildTID:  binary.LittleEndian.Uint32(event.Data[8:12]),
+            Thread:    binary.LittleEndian.Uint32(event.Data[12:16]) != 0,
+        }
         processed.Type = "CLONE"
-        processed.Details = "Process cloned"
+        processed.Process = info
+        if info.Thread {
+            processed.Details = fmt.Sprintf("New thread: TID %d", info.ChildTID)
+        } else {
+            processed.Details = fmt.Sprintf("New process: PID %d, Parent: %d", info.ChildPID, info.ParentPID)
+        }
 
     case EVENT_TYPE_EXIT:
+        info := &ProcessInfo{
+            Runtime:    time.Duration(binary.LittleEndian.Uint64(event.Data[0:8])),
+            ExitCode:   int32(binary.LittleEndian.Uint32(event.Data[8:12])),
+            Signal:     binary.LittleEndian.Uint32(event.Data[12:16]),
+            CoreDumped: binary.LittleEndian.Uint32(event.Data[16:20]) != 0,
+            Thread:     binary.LittleEndian.Uint32(event.Data[20:24]) != 0,
+            ParentPID:  binary.LittleEndian.Uint32(event.Data[24:28]),
+        }
         processed.Type = "EXIT"
-        processed.Details = "Process exited"
+        processed.Process = info
+        what := "Process"
+        if info.Thread {
+            what = "Thread"
+        }
+        status := fmt.Sprintf("exited with code %d", info.ExitCode)
+        if info.Signal != 0 {
+            status = "killed by " + signalName(int32(info.Signal))
+            if !info.Thread {
+                if s, ok := p.kills.Exited(event.PID, int32(info.Signal), processed.Timestamp); ok && s.oom {
+                    info.OOMKilled = true
+                    info.KillerPID, info.KillerComm = s.senderPID, s.senderComm
+                    status += fmt.Sprintf(" from OOM killer (triggered by PID %d (%s))", s.senderPID, s.senderComm)
+                } else if ok && s.kernel {
+                    status += " from kernel"
+                } else if ok {
+                    info.KillerPID, info.KillerComm = s.senderPID, s.senderComm
+                    status += fmt.Sprintf(" from PID %d (%s)", s.senderPID, s.senderComm)
+                }
+            }
+            if info.CoreDumped {
+                status += " (core dumped)"
+            }
+        }
+        processed.Details = fmt.Sprintf("%s %s after %s, PPID: %d",
+            what, status, info.Runtime.Round(time.Millisecond), info.ParentPID)
 
-    case EVENT_TYPE_TCP_CONN:
-        if len(event.Data) < 12 {
-            return nil
+    case EVENT_TYPE_SIGNAL:
+        info := &SignalInfo{
+            TargetPID:  binary.LittleEndian.Uint32(event.Data[0:4]),
+            TargetTID:  binary.LittleEndian.Uint32(event.Data[4:8]),
+            Signal:     int32(binary.LittleEndian.Uint32(event.Data[8:12])),
+            Code:       int32(binary.LittleEndian.Uint32(event.Data[12:16])),
+            Result:     int32(binary.LittleEndian.Uint32(event.Data[16:20])),
+            Source:     signalSourceNames[binary.LittleEndian.Uint32(event.Data[20:24])],
+            SenderPID:  binary.LittleEndian.Uint32(event.Data[24:28]),
+            TargetComm: sanitizeUTF8(cString(event.Data[28:44])),
         }
-        saddr := binary.LittleEndian.Uint32(event.Data[0:4])
-        daddr := binary.LittleEndian.Uint32(event.Data[4:8])
-        sport := binary.LittleEndian.Uint16(event.Data[8:10])
-        dport := binary.LittleEndian.Uint16(event.Data[10:12])
+        switch info.Source {
+        case "deliver":
+            // Доставка идёт в контексте получателя; отправителя знаем только по si_pid
+            if info.SenderPID != 0 {
+                info.SenderComm = p.tree.Comm(info.SenderPID)
+            }
+        default:
+            info.SenderComm = processed.Comm
+        }
+        processed.Type = "SIGNAL"
+        processed.Signal = info
+        name := signalName(info.Signal)
+        switch info.Source {
+        case "generate":
+            processed.Details = fmt.Sprintf("%s from %d (%s) to %d (%s), TID: %d, Result: %s",
+                name, info.SenderPID, info.SenderComm, info.TargetPID, info.TargetComm,
+                info.TargetTID, signalResultName(info.Result))
+        case "deliver":
+            from := "kernel"
+            if info.Code <= 0 {
+                from = fmt.Sprintf("%d (%s)", info.SenderPID, info.SenderComm)
+            }
+            processed.Details = fmt.Sprintf("%s delivered to %d (%s), From: %s",
+                name, info.TargetPID, info.TargetComm, from)
+        case "tgkill":
+            processed.Details = fmt.Sprintf("tgkill(%d, %d, %s) = %d",
+                int32(info.TargetPID), info.TargetTID, name, info.Result)
+        case "tkill":
+            processed.Details = fmt.Sprintf("tkill(%d, %s) = %d", info.TargetTID, name, info.Result)
+        default:
+            processed.Details = fmt.Sprintf("kill(%d, %s) = %d", int32(info.TargetPID), name, info.Result)
+        }
+
+    case EVENT_TYPE_CRED:
+        u32 := func(off int) uint32 { return binary.LittleEndian.Uint32(event.Data[off : off+4]) }
+        info := &CredInfo{
+            OldUID: u32(0), OldEUID: u32(4), OldGID: u32(8), OldEGID: u32(12),
+            NewUID: u32(16), NewEUID: u32(20), NewGID: u32(24), NewEGID: u32(28),
+            OldCaps: binary.LittleEndian.Uint64(event.Data[32:40]),
+            NewCaps: binary.LittleEndian.Uint64(event.Data[40:48]),
+        }
+        info.Gained = capabilityList(info.NewCaps &^ info.OldCaps)
+        info.Lost = capabilityList(info.OldCaps &^ info.NewCaps)
+        processed.Type = "CRED"
+        processed.Cred = info
+        processed.Details = fmt.Sprintf("uid %d→%d, euid %d→%d, gid %d→%d, egid %d→%d",
+            info.OldUID, info.NewUID, info.OldEUID, info.NewEUID,
+            info.OldGID, info.NewGID, info.OldEGID, info.NewEGID)
+        if info.OldCaps != info.NewCaps {
+            processed.Details += fmt.Sprintf(", Caps: %s→%s", describeCaps(info.OldCaps), describeCaps(info.NewCaps))
+        }
+
+    case EVENT_TYPE_CAPABLE:
+        info := &CapabilityInfo{
+            Cap:  int32(binary.LittleEndian.Uint32(event.Data[0:4])),
+            Opts: binary.LittleEndian.Uint32(event.Data[8:12]),
+        }
+        info.Name = capabilityName(info.Cap)
+        info.Allowed = binary.LittleEndian.Uint32(event.Data[4:8]) == 0
+        processed.Type = "CAPABLE"
+        processed.Capability = info
+        result := "granted"
+        if !info.Allowed {
+            result = "denied"
+        }
+        processed.Details = fmt.Sprintf("%s %s", info.Name, result)
 
-        srcIP := net.IPv4(byte(saddr), byte(saddr>>8), byte(saddr>>16), byte(saddr>>24))
-        dstIP := net.IPv4(byte(daddr), byte(daddr>>8), byte(daddr>>16), byte(daddr>>24))
+    case EVENT_TYPE_MODULE:
+        info := &ModuleInfo{
+            Name:   sanitizeUTF8(cString(event.Data[0:56])),
+            Op:     moduleOpNames[binary.LittleEndian.Uint32(event.Data[56:60])],
+            Result: int32(binary.LittleEndian.Uint32(event.Data[60:64])),
+            FD:     int32(binary.LittleEndian.Uint32(event.Data[64:68])),
+            Flags:  binary.LittleEndian.Uint32(event.Data[68:72]),
+        }
+        if info.Op == "finit_module" {
+            info.Path = sanitizeUTF8(dentryPath(event.Payload))
+            if info.Path == "" {
+                info.Path = p.fds.Resolve(event.PID, info.FD)
+            }
+        }
+        processed.Type = "MODULE"
+        processed.Module = info
+        name := info.Name
+        if name == "" {
+            name = "?"
+        }
+        processed.Details = fmt.Sprintf("%s %s", info.Op, name)
+        if info.Path != "" {
+            processed.Details += ", File: " + info.Path
+        }
+        processed.Details += fmt.Sprintf(", Result: %d", info.Result)
 
-        processed.Type = "TCP_CONN"
-        processed.Details = fmt.Sprintf("%s:%d -> %s:%d", srcIP, sport, dstIP, dport)
+    case EVENT_TYPE_BPF:
+        cmd := int32(binary.LittleEndian.Uint32(event.Data[0:4]))
+        info := &BPFInfo{
+            Command: bpfCommandName(cmd),
+            Result:  int32(binary.LittleEndian.Uint32(event.Data[4:8])),
+            Type:    bpfTypeName(cmd, binary.LittleEndian.Uint32(event.Data[8:12])),
+            InsnCnt: binary.LittleEndian.Uint32(event.Data[12:16]),
+            ProgFD:  int32(binary.LittleEndian.Uint32(event.Data[16:20])),
+            Name:    sanitizeUTF8(cString(event.Data[20:84])),
+        }
+        processed.Type = "BPF"
+        processed.BPF = info
+        processed.Details = info.Command
+        if info.Type != "" {
+            processed.Details += " " + info.Type
+        }
+        if info.Name != "" {
+            processed.Details += fmt.Sprintf(" %q", info.Name)
+        }
+        if info.InsnCnt != 0 {
+            processed.Details += fmt.Sprintf(", %d insns", info.InsnCnt)
+        }
+        if info.ProgFD >= 0 {
+            processed.Details += fmt.Sprintf(", Prog FD: %d", info.ProgFD)
+        }
+        processed.Details += fmt.Sprintf(", Result: %d", info.Result)
+
+    case EVENT_TYPE_MMAP_EXEC:
+        info := &MemoryMapInfo{
+            Addr:   binary.LittleEndian.Uint64(event.Data[0:8]),
+            Length: binary.LittleEndian.Uint64(event.Data[8:16]),
+            Prot:   binary.LittleEndian.Uint32(event.Data[16:20]),
+            Flags:  binary.LittleEndian.Uint32(event.Data[20:24]),
+            FD:     int32(binary.LittleEndian.Uint32(event.Data[24:28])),
+            Op:     mmapOpNames[binary.LittleEndian.Uint32(event.Data[28:32])],
+            Result: int64(binary.LittleEndian.Uint64(event.Data[40:48])),
+        }
+        vmFlags := binary.LittleEndian.Uint64(event.Data[32:40])
+        info.File = sanitizeUTF8(dentryPath(event.Payload))
+        info.WriteExec = info.Prot&unix.PROT_WRITE != 0
+        switch info.Op {
+        case "mmap":
+            info.Anonymous = info.Flags&unix.MAP_ANONYMOUS != 0
+        case "mprotect":
+            // vm_flags == 0 — хук security_file_mprotect недоступен, о регионе ничего не знаем
+            if vmFlags != 0 {
+                info.PrevProt = uint32(vmFlags) & (unix.PROT_READ | unix.PROT_WRITE | unix.PROT_EXEC)
+                info.Anonymous = info.File == ""
+                info.WXTransition = info.PrevProt&unix.PROT_WRITE != 0 && info.PrevProt&unix.PROT_EXEC == 0
+            }
+        }
+        processed.Type = "MMAP_EXEC"
+        processed.MemoryMap = info
+        processed.Details = fmt.Sprintf("%s 0x%x-0x%x %s", info.Op, info.Addr, info.Addr+info.Length, protString(info.Prot))
+        if info.Op == "mmap" {
+            processed.Details += " " + mmapFlagsString(info.Flags)
+        } else if vmFlags != 0 {
+            processed.Details += fmt.Sprintf(" (was %s)", protString(info.PrevProt))
+        }
+        if info.File != "" {
+            processed.Details += ", File: " + info.File
+        }
+        switch {
+        case info.Anonymous && info.WriteExec:
+            processed.Details += ", ANONYMOUS RWX"
+        case info.Anonymous:
+            processed.Details += ", ANONYMOUS"
+        case info.WriteExec:
+            processed.Details += ", RWX"
+        }
+        if info.WXTransition {
+            processed.Details += ", W→X"
+        }
+        if info.Result < 0 {
+            processed.Details += fmt.Sprintf(", Result: %d", info.Result)
+        }
+
+    case EVENT_TYPE_NAMESPACE:
+        info := &NamespaceInfo{
+            Op:     nsOpNames[binary.LittleEndian.Uint32(event.Data[0:4])],
+            Result: int32(binary.LittleEndian.Uint32(event.Data[4:8])),
+            Flags:  decodeFlags(binary.LittleEndian.Uint64(event.Data[8:16]), cloneFlagNames),
+            FD:     int32(binary.LittleEndian.Uint32(event.Data[16:20])),
+            Target: binary.LittleEndian.Uint32(event.Data[20:24]),
+            Before: parseNamespaceSet(event.Data[24:56]),
+            After:  parseNamespaceSet(event.Data[56:88]),
+        }
+        info.Changed = info.Before.Changes(info.After)
+        processed.Type = "NAMESPACE"
+        processed.Namespace = info
+        if info.Op == "setns" {
+            processed.Details = fmt.Sprintf("setns(%d, %s) = %d", info.FD, joinFlags(info.Flags), info.Result)
+            if info.Target != 0 {
+                processed.Details += fmt.Sprintf(", Target: %d", info.Target)
+            }
+        } else {
+            processed.Details = fmt.Sprintf("unshare(%s) = %d", joinFlags(info.Flags), info.Result)
+        }
+        if len(info.Changed) > 0 {
+            processed.Details += ", " + strings.Join(info.Changed, ", ")
+        }
+
+    case EVENT_TYPE_MOUNT:
+        op := binary.LittleEndian.Uint32(event.Data[0:4])
+        flags := binary.LittleEndian.Uint64(event.Data[8:16])
+        info := &MountInfo{
+            Op:      mountOpNames[op],
+            Result:  int32(binary.LittleEndian.Uint32(event.Data[4:8])),
+            MountNS: binary.LittleEndian.Uint32(event.Data[16:20]),
+            FSType:  sanitizeUTF8(cString(event.Data[20:52])),
+            Target:  sanitizeUTF8(p.resolveAtPath(event.PID, atFDCWD, cString(event.Data[52:296]))),
+            Source:  sanitizeUTF8(cString(event.Payload)),
+        }
+        if op == MOUNT_OP_UMOUNT {
+            info.Flags = decodeFlags(flags, umountFlagNames)
+        } else {
+            info.Flags = decodeFlags(flags, mountFlagNames)
+        }
+        // Источник — путь только у bind/move и pivot_root, иначе это устройство или "proc", "tmpfs"
+        if op == MOUNT_OP_PIVOT_ROOT || flags&(unix.MS_BIND|unix.MS_MOVE) != 0 {
+            info.Source = p.resolveAtPath(event.PID, atFDCWD, info.Source)
+        }
+        processed.Type = "MOUNT"
+        processed.Mount = info
+        switch op {
+        case MOUNT_OP_MOUNT:
+            processed.Details = fmt.Sprintf("mount %s on %s", info.Source, info.Target)
+            if info.FSType != "" {
+                processed.Details += ", Type: " + info.FSType
+            }
+            processed.Details += ", Flags: " + joinFlags(info.Flags)
+        case MOUNT_OP_UMOUNT:
+            processed.Details = fmt.Sprintf("umount %s, Flags: %s", info.Target, joinFlags(info.Flags))
+        default:
+            processed.Details = fmt.Sprintf("pivot_root %s, Old root: %s", info.Target, info.Source)
+        }
+        processed.Details += fmt.Sprintf(", Mount NS: %d, Result: %d", info.MountNS, info.Result)
+
+    case EVENT_TYPE_OOM:
+        info := &OOMInfo{
+            VictimPID:   binary.LittleEndian.Uint32(event.Data[0:4]),
+            OOMScoreAdj: int32(binary.LittleEndian.Uint32(event.Data[4:8])),
+            VictimComm:  sanitizeUTF8(cString(event.Data[8:24])),
+            AnonRSS:     binary.LittleEndian.Uint64(event.Data[24:32]) * pageSize,
+            FileRSS:     binary.LittleEndian.Uint64(event.Data[32:40]) * pageSize,
+            ShmemRSS:    binary.LittleEndian.Uint64(event.Data[40:48]) * pageSize,
+            TotalMemory: binary.LittleEndian.Uint64(event.Data[48:56]) * pageSize,
+            MemcgID:     binary.LittleEndian.Uint64(event.Data[56:64]),
+            Points:      int64(binary.LittleEndian.Uint64(event.Data[64:72])),
+            Constraint:  oomConstraintName(binary.LittleEndian.Uint32(event.Data[72:76])),
+            VictimUID:   binary.LittleEndian.Uint32(event.Data[76:80]),
+        }
+        if info.MemcgID != 0 {
+            info.MemcgPath, _ = p.cgroups.Resolve(info.MemcgID)
+        }
+        processed.Type = "OOM"
+        processed.OOM = info
+        processed.Details = fmt.Sprintf("Killed %d (%s), RSS: %s (anon %s, file %s, shmem %s), oom_score_adj: %d",
+            info.VictimPID, info.VictimComm, formatBytes(info.AnonRSS+info.FileRSS+info.ShmemRSS),
+            formatBytes(info.AnonRSS), formatBytes(info.FileRSS), formatBytes(info.ShmemRSS), info.OOMScoreAdj)
+        if info.MemcgPath != "" {
+            processed.Details += fmt.Sprintf(", Memcg: %s (limit %s)", info.MemcgPath, formatBytes(info.TotalMemory))
+        } else {
+            processed.Details += fmt.Sprintf(", Constraint: %s", info.Constraint)
+        }
+
+    case EVENT_TYPE_SYSCALL:
+        info := &SyscallInfo{
+            Nr:       binary.LittleEndian.Uint32(event.Data[0:4]),
+            Ret:      int64(binary.LittleEndian.Uint64(event.Data[56:64])),
+            Duration: time.Duration(binary.LittleEndian.Uint64(event.Data[64:72])),
+        }
+        for i := range info.Args {
+            info.Args[i] = binary.LittleEndian.Uint64(event.Data[8+i*8:])
+        }
+        info.Name = syscallName(info.Nr)
+        processed.Type = "SYSCALL"
+        processed.Syscall = info
+        processed.Details = formatSyscall(info)
+
+    case EVENT_TYPE_BLOCK_IO:
+        dev := binary.LittleEndian.Uint32(event.Data[0:4])
+        info := &BlockIOInfo{
+            Major:   kernelDevMajor(dev),
+            Minor:   kernelDevMinor(dev),
+            Device:  blockDevices.Name(dev),
+            RWBS:    cString(event.Data[36:44]),
+            Sector:  binary.LittleEndian.Uint64(event.Data[8:16]),
+            Bytes:   binary.LittleEndian.Uint32(event.Data[4:8]),
+            Latency: time.Duration(binary.LittleEndian.Uint64(event.Data[16:24])),
+            Service: time.Duration(binary.LittleEndian.Uint64(event.Data[24:32])),
+            Error:   int32(binary.LittleEndian.Uint32(event.Data[32:36])),
+        }
+        info.Op = blockOpName(info.RWBS)
+        processed.Type = "BLOCK_IO"
+        processed.BlockIO = info
+        processed.Details = fmt.Sprintf("%s %s (%d:%d), Sector: %d, Size: %s, Latency: %v (device %v)",
+            info.Op, info.Device, info.Major, info.Minor, info.Sector, formatBytes(uint64(info.Bytes)),
+            info.Latency, info.Service)
+        if info.RWBS != "" {
+            processed.Details += ", Flags: " + info.RWBS
+        }
+        if info.Error != 0 {
+            processed.Details += fmt.Sprintf(", Error: %d", info.Error)
+        }
+
+    case EVENT_TYPE_TCP_CONN, EVENT_TYPE_UDP, EVENT_TYPE_DNS:
+        if len(event.Data) < 44 {
+            return nil
+        }
+        // Адреса приходят IPv4-mapped, для показа разворачиваем обратно
+        conn := &ConnectionInfo{
+            Protocol:  "tcp",
+            SrcIP:     netip.AddrFrom16([16]byte(event.Data[0:16])).Unmap(),
+            DstIP:     netip.AddrFrom16([16]byte(event.Data[16:32])).Unmap(),
+            SrcPort:   binary.LittleEndian.Uint16(event.Data[32:34]),
+            DstPort:   binary.LittleEndian.Uint16(event.Data[34:36]),
+            Direction: binary.LittleEndian.Uint16(event.Data[38:40]),
+            Length:    binary.LittleEndian.Uint32(event.Data[40:44]),
+        }
+        if event.Type != EVENT_TYPE_TCP_CONN {
+            conn.Protocol = "udp"
+        }
+        remote := conn.DstIP
+        if conn.Direction == TCP_DIR_IN {
+            remote = conn.SrcIP
+        }
+        conn.Hostname = p.dns.Lookup(event.PID, remote, processed.Timestamp)
+        processed.Connection = conn
+        processed.Details = fmt.Sprintf("%s -> %s (%s)",
+            netip.AddrPortFrom(conn.SrcIP, conn.SrcPort), netip.AddrPortFrom(conn.DstIP, conn.DstPort),
+            directionName(conn.Direction))
+        if conn.Hostname != "" {
+            processed.Details += fmt.Sprintf(", Host: %s", conn.Hostname)
+        }
+
+        switch event.Type {
+        case EVENT_TYPE_TCP_CONN:
+            processed.Type = "TCP_CONN"
+        case EVENT_TYPE_UDP:
+            processed.Type = "UDP"
+            processed.Details += fmt.Sprintf(", Bytes: %d", conn.Length)
+        case EVENT_TYPE_DNS:
+            processed.Type = "DNS"
+            info, err := parseDNS(event.Payload)
+            if err != nil {
+                processed.Details += fmt.Sprintf(", Undecodable DNS message: %v", err)
+                break
+            }
+            conn.DNS = info
+            if !info.Response {
+                processed.Details = fmt.Sprintf("Query %s %s [%s]", info.Name, info.Type, processed.Details)
+                break
+            }
+            processed.Details = fmt.Sprintf("Response %s %s %s -> %s [%s]", info.Name, info.Type,
+                info.RCode, strings.Join(info.Answers, ", "), processed.Details)
+        }
 
     case EVENT_TYPE_UPROBE:
         if len(event.Data) < 96 { // 64 + 4*8
@@ -165,3 +844,51 @@ func (p *Processor) processEvent(event EventRaw) *ProcessedEvent {
     processed.Details = sanitizeUTF8(processed.Details)
     return processed
 }
+
+// cString обрезает строку по первому NUL: буфер в per-CPU scratch не
+// обнуляется, после терминатора может лежать хвост предыдущего события
+func cString(b []byte) string {
+    if i := bytes.IndexByte(b, 0); i >= 0 {
+        b = b[:i]
+    }
+    return string(b)
+}
+
+// resolveAtPath делает относительный путь *at()-вызова абсолютным: относительно
+// каталога dfd или cwd процесса. Если каталог узнать не удалось, путь остаётся как есть.
+func (p *Processor) resolveAtPath(pid uint32, dfd int32, path string) string {
+    if path == "" || strings.HasPrefix(path, "/") {
+        return path
+    }
+    var dir string
+    if dfd == atFDCWD {
+        dir, _ = os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+    } else {
+        dir = p.fds.Resolve(pid, dfd)
+    }
+    if !strings.HasPrefix(dir, "/") {
+        return path
+    }
+    return filepath.Join(dir, path)
+}
+
+// renderPayload показывает первые max байт в виде hex и ASCII ('.' для непечатаемых)
+func renderPayload(data []byte, max int) string {
+    shown := data
+    if len(shown) > max {
+        shown = shown[:max]
+    }
+    ascii := make([]byte, len(shown))
+    for i, b := range shown {
+        if b >= 0x20 && b < 0x7f {
+            ascii[i] = b
+        } else {
+            ascii[i] = '.'
+        }
+    }
+    suffix := ""
+    if len(data) > len(shown) {
+        suffix = "..."
+    }
+    return fmt.Sprintf("Hex: %s%s, ASCII: %s%s", hex.EncodeToString(shown), suffix, ascii, suffix)
+}
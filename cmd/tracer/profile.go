@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+// struct profile_key в tracer.h
+type profileKey struct {
+	PID           uint32
+	UserStackID   int32
+	KernelStackID int32
+	Comm          [16]byte
+}
+
+// ProfileSample is one distinct stack of a process with the number of
+// samples that hit it.
+type ProfileSample struct {
+	PID         uint32
+	Comm        string // comm потока
+	UserStack   []StackFrame
+	KernelStack []StackFrame // от вершины стека вниз, над UserStack
+	Count       uint64
+}
+
+// Из всего объекта профилировщику нужна одна программа и её карты
+type profileObjects struct {
+	Program *ebpf.Program `ebpf:"handle_profile"`
+	Counts  *ebpf.Map     `ebpf:"profile_counts"`
+	Stacks  *ebpf.Map     `ebpf:"profile_stacks"`
+	Dropped *ebpf.Map     `ebpf:"profile_dropped"`
+	Config  *ebpf.Map     `ebpf:"config"`
+	PIDs    *ebpf.Map     `ebpf:"pid_filters"`
+	Cgroups *ebpf.Map     `ebpf:"cgroup_filters"`
+}
+
+// Profiler samples on-CPU stacks with a CPU_CLOCK perf event on every CPU
+// and counts them in the kernel. Unlike Loader it attaches nothing else.
+type Profiler struct {
+	objs   profileObjects
+	events []int // perf_event fd-ы, по одному на CPU
+}
+
+func NewProfiler() (*Profiler, error) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return nil, fmt.Errorf("remove memlock: %w", err)
+	}
+	spec, err := ebpf.LoadCollectionSpec("bpf/tracer.bpf.o")
+	if err != nil {
+		return nil, fmt.Errorf("load collection spec: %w", err)
+	}
+	p := &Profiler{}
+	if err := spec.LoadAndAssign(&p.objs, nil); err != nil {
+		return nil, fmt.Errorf("load profile program: %w", err)
+	}
+	return p, nil
+}
+
+// SetPIDs restricts sampling to the given processes (all of their threads).
+func (p *Profiler) SetPIDs(pids []uint32) error {
+	if len(pids) == 0 {
+		return nil
+	}
+	for _, pid := range pids {
+		if err := p.objs.PIDs.Put(pid, ^uint32(0)); err != nil {
+			return fmt.Errorf("set pid filter %d: %w", pid, err)
+		}
+	}
+	return p.objs.Config.Put(uint32(CONFIG_PID_FILTER), uint64(1))
+}
+
+// SetCgroups restricts sampling to tasks in the given cgroup v2 paths or
+// below them.
+func (p *Profiler) SetCgroups(paths []string) error {
+	n, err := fillCgroupFilter(p.objs.Cgroups, paths)
+	if err != nil || n == 0 {
+		return err
+	}
+	return p.objs.Config.Put(uint32(CONFIG_CGROUP_FILTER), uint64(n))
+}
+
+// Start opens a perf event sampling freq times a second on each online CPU
+// and attaches the program to it.
+func (p *Profiler) Start(freq uint64) error {
+	cpus, err := onlineCPUs()
+	if err != nil {
+		return err
+	}
+	attr := unix.PerfEventAttr{
+		Type:   unix.PERF_TYPE_SOFTWARE,
+		Config: unix.PERF_COUNT_SW_CPU_CLOCK,
+		Size:   uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+		Sample: freq,
+		Bits:   unix.PerfBitFreq | unix.PerfBitDisabled,
+	}
+	for _, cpu := range cpus {
+		fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			p.Stop()
+			return fmt.Errorf("perf_event_open on CPU %d: %w", cpu, err)
+		}
+		p.events = append(p.events, fd)
+		if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, p.objs.Program.FD()); err != nil {
+			p.Stop()
+			return fmt.Errorf("attach to perf event on CPU %d: %w", cpu, err)
+		}
+	}
+	// Включаем после привязки ко всем CPU, чтобы сэмплы начались одновременно
+	for _, fd := range p.events {
+		if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+			p.Stop()
+			return fmt.Errorf("enable perf event: %w", err)
+		}
+	}
+	return nil
+}
+
+// Stop closes the perf events; the counts stay in the map.
+func (p *Profiler) Stop() {
+	for _, fd := range p.events {
+		unix.Close(fd)
+	}
+	p.events = nil
+}
+
+func (p *Profiler) Close() {
+	p.Stop()
+	p.objs.Program.Close()
+	p.objs.Counts.Close()
+	p.objs.Stacks.Close()
+	p.objs.Dropped.Close()
+	p.objs.Config.Close()
+	p.objs.PIDs.Close()
+	p.objs.Cgroups.Close()
+}
+
+// Samples reads the counts and symbolizes their stacks. lost is the number
+// of samples whose stack did not fit into profile_stacks.
+func (p *Profiler) Samples() (samples []ProfileSample, lost uint64, err error) {
+	sym := NewSymbolizer(p.objs.Stacks)
+	var key profileKey
+	var count uint64
+	it := p.objs.Counts.Iterate()
+	for it.Next(&key, &count) {
+		if key.UserStackID == -int32(unix.EEXIST) || key.KernelStackID == -int32(unix.EEXIST) {
+			lost += count
+		}
+		samples = append(samples, ProfileSample{
+			PID:         key.PID,
+			Comm:        sanitizeUTF8(cString(key.Comm[:])),
+			UserStack:   sym.User(key.PID, key.UserStackID),
+			KernelStack: sym.Kernel(key.KernelStackID),
+			Count:       count,
+		})
+	}
+	if err := it.Err(); err != nil {
+		return nil, 0, fmt.Errorf("read profile_counts: %w", err)
+	}
+	return samples, lost, nil
+}
+
+// Dropped returns how many samples were not counted because profile_counts
+// was full.
+func (p *Profiler) Dropped() (uint64, error) {
+	var dropped uint64
+	if err := p.objs.Dropped.Lookup(uint32(0), &dropped); err != nil {
+		return 0, fmt.Errorf("read profile_dropped: %w", err)
+	}
+	return dropped, nil
+}
+
+// onlineCPUs parses /sys/devices/system/cpu/online, e.g. "0-3,6,8-9";
+// perf_event_open fails on offline CPUs.
+func onlineCPUs() ([]int, error) {
+	data, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for _, r := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		first, last, isRange := strings.Cut(r, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("parse online CPUs %q: %w", data, err)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("parse online CPUs %q: %w", data, err)
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, errors.New("no online CPUs")
+	}
+	return cpus, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runProfileCommand implements `tracer profile`: samples on-CPU stacks for a
+// while and writes them as a pprof profile and as folded stacks.
+func runProfileCommand(args []string) {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	duration := fs.Duration("duration", 30*time.Second, "How long to profile; Ctrl+C stops earlier")
+	output := fs.String("o", "cpu.pprof", "pprof output file (gzipped profile.proto)")
+	folded := fs.String("folded", "", "Folded stacks output file for flamegraph.pl (default: -o with the extension .folded, \"-\" for stdout)")
+	freq := fs.Uint64("frequency", 99, "Samples per second on each CPU")
+	pidFlag := fs.String("pid", "", "Comma-separated PIDs to profile (all threads of each)")
+	cgroupFlag := fs.String("cgroup", "", "Comma-separated cgroup v2 paths to profile (relative to /sys/fs/cgroup), including their sub-cgroups")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s profile [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *freq == 0 {
+		log.Fatalf("--frequency must be positive")
+	}
+	if *folded == "" {
+		*folded = strings.TrimSuffix(*output, filepath.Ext(*output)) + ".folded"
+	}
+
+	profiler, err := NewProfiler()
+	if err != nil {
+		log.Fatalf("Failed to load eBPF: %v", err)
+	}
+	defer profiler.Close()
+
+	pids, err := parseUint32List(*pidFlag)
+	if err != nil {
+		log.Fatalf("Invalid --pid: %v", err)
+	}
+	if err := profiler.SetPIDs(pids); err != nil {
+		log.Fatalf("Failed to set PID filter: %v", err)
+	}
+	if *cgroupFlag != "" {
+		if err := profiler.SetCgroups(strings.Split(*cgroupFlag, ",")); err != nil {
+			log.Fatalf("Failed to set cgroup filter: %v", err)
+		}
+	}
+
+	start := time.Now()
+	if err := profiler.Start(*freq); err != nil {
+		log.Fatalf("Failed to start sampling: %v", err)
+	}
+	log.Printf("Profiling at %d Hz for %v, Ctrl+C to stop", *freq, *duration)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-time.After(*duration):
+	case <-sig:
+	}
+	profiler.Stop()
+	elapsed := time.Since(start)
+
+	samples, lost, err := profiler.Samples()
+	if err != nil {
+		log.Fatalf("Failed to read samples: %v", err)
+	}
+	var total uint64
+	for _, s := range samples {
+		total += s.Count
+	}
+	if lost > 0 {
+		log.Printf("%d samples lost their stack: profile_stacks is full", lost)
+	}
+	if dropped, err := profiler.Dropped(); err != nil {
+		log.Printf("Failed to read dropped samples: %v", err)
+	} else if dropped > 0 {
+		log.Printf("%d samples dropped: profile_counts is full (40960 stack pairs)", dropped)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *output, err)
+	}
+	if err := writePprof(f, samples, *freq, start, elapsed); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+
+	if *folded == "-" {
+		if err := writeFolded(os.Stdout, samples); err != nil {
+			log.Fatalf("Failed to write folded stacks: %v", err)
+		}
+	} else {
+		f, err := os.Create(*folded)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *folded, err)
+		}
+		if err := writeFolded(f, samples); err != nil {
+			log.Fatalf("Failed to write %s: %v", *folded, err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Failed to write %s: %v", *folded, err)
+		}
+	}
+	log.Printf("%d samples, %d distinct stacks written to %s and %s", total, len(samples), *output, *folded)
+}
@@ -10,6 +10,9 @@ import (
 
 // НЕ определяй здесь Event! Используй EventRaw из event.go
 
+// Размер заголовка struct event до union (с учётом выравнивания u64)
+const eventHeaderSize = 80
+
 type Reader struct {
     collection *ebpf.Collection
 }
@@ -33,18 +36,31 @@ func (r *Reader) Start(out chan<- EventRaw) {
             continue
         }
 
-        if len(record.RawSample) < 36+len(EventRaw{}.Data) {
+        if len(record.RawSample) < eventHeaderSize+len(EventRaw{}.Data) {
             log.Printf("Invalid event size: %d", len(record.RawSample))
             continue
         }
 
         var event EventRaw
         event.Type = binary.LittleEndian.Uint32(record.RawSample[0:4])
+        event.Hidden = event.Type&EVENT_FLAG_HIDDEN != 0
+        event.Type &^= EVENT_FLAG_HIDDEN
         event.PID = binary.LittleEndian.Uint32(record.RawSample[4:8])
-        event.Tgid = binary.LittleEndian.Uint32(record.RawSample[8:12])
-        event.Timestamp = binary.LittleEndian.Uint64(record.RawSample[12:20])
-        copy(event.Comm[:], record.RawSample[20:36])
-        copy(event.Data[:], record.RawSample[36:36+len(event.Data)])
+        event.TID = binary.LittleEndian.Uint32(record.RawSample[8:12])
+        event.NsPID = binary.LittleEndian.Uint32(record.RawSample[12:16])
+        event.Timestamp = binary.LittleEndian.Uint64(record.RawSample[16:24])
+        event.CgroupID = binary.LittleEndian.Uint64(record.RawSample[24:32])
+        event.UID = binary.LittleEndian.Uint32(record.RawSample[32:36])
+        event.GID = binary.LittleEndian.Uint32(record.RawSample[36:40])
+        copy(event.Comm[:], record.RawSample[40:56])
+        copy(event.ThreadComm[:], record.RawSample[56:72])
+        event.UserStackID = int32(binary.LittleEndian.Uint32(record.RawSample[72:76]))
+        event.KernelStackID = int32(binary.LittleEndian.Uint32(record.RawSample[76:80]))
+        copy(event.Data[:], record.RawSample[eventHeaderSize:eventHeaderSize+len(event.Data)])
+        if tail := record.RawSample[eventHeaderSize+len(event.Data):]; len(tail) > 0 {
+            // RawSample переиспользуется ридером — копируем
+            event.Payload = append([]byte(nil), tail...)
+        }
 
         select {
         case out <- event:
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Источник SIGNAL-события, SIGNAL_* в tracer.h
+const (
+	SIGNAL_GENERATE = 1
+	SIGNAL_DELIVER  = 2
+	SIGNAL_KILL     = 3
+	SIGNAL_TGKILL   = 4
+	SIGNAL_TKILL    = 5
+)
+
+var signalSourceNames = map[uint32]string{
+	SIGNAL_GENERATE: "generate",
+	SIGNAL_DELIVER:  "deliver",
+	SIGNAL_KILL:     "kill",
+	SIGNAL_TGKILL:   "tgkill",
+	SIGNAL_TKILL:    "tkill",
+}
+
+// Результат signal_generate (enum trace_signal_result в ядре)
+var signalResultNames = []string{"delivered", "ignored", "already pending", "overflow", "lost info"}
+
+// Не даём таблице расти без конца, если EXIT-ы не приходят (отфильтрованы)
+const killTrackerMaxEntries = 65536
+
+// Запись о последнем сигнале не старше этого считаем причиной выхода
+const killTrackerTTL = time.Minute
+
+func signalName(sig int32) string {
+	if name := unix.SignalName(syscall.Signal(sig)); name != "" {
+		return name
+	}
+	if sig >= 34 && sig <= 64 {
+		return fmt.Sprintf("SIGRT%d", sig-34)
+	}
+	return fmt.Sprintf("SIG%d", sig)
+}
+
+func signalResultName(result int32) string {
+	if result >= 0 && int(result) < len(signalResultNames) {
+		return signalResultNames[result]
+	}
+	return fmt.Sprintf("%d", result)
+}
+
+type sentSignal struct {
+	sig        int32
+	senderPID  uint32
+	senderComm string
+	kernel     bool
+	oom        bool // убит OOM killer-ом; sender — процесс, вызвавший OOM
+	at         time.Time
+}
+
+// KillTracker remembers the last signal sent to each process, so that an
+// EXIT caused by a signal can name the sender.
+type KillTracker struct {
+	mu   sync.Mutex
+	last map[uint32]sentSignal
+}
+
+func NewKillTracker() *KillTracker {
+	return &KillTracker{last: make(map[uint32]sentSignal)}
+}
+
+// Sent records a signal generated for target. Kernel-originated signals
+// (si_code > 0, e.g. SIGSEGV on a fault) are recorded as such.
+func (k *KillTracker) Sent(target uint32, sig int32, code int32, senderPID uint32, senderComm string, at time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.last) >= killTrackerMaxEntries {
+		k.last = make(map[uint32]sentSignal)
+	}
+	// SIGKILL, который OOM killer шлёт жертве, приходит после OOM-события — не затираем его
+	if prev, ok := k.last[target]; ok && prev.oom && prev.sig == sig && code > 0 {
+		return
+	}
+	k.last[target] = sentSignal{sig: sig, senderPID: senderPID, senderComm: senderComm, kernel: code > 0, at: at}
+}
+
+// OOMKilled records that the OOM killer chose victim while trigger was
+// allocating memory.
+func (k *KillTracker) OOMKilled(victim, trigger uint32, triggerComm string, at time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.last) >= killTrackerMaxEntries {
+		k.last = make(map[uint32]sentSignal)
+	}
+	k.last[victim] = sentSignal{sig: int32(unix.SIGKILL), senderPID: trigger, senderComm: triggerComm, oom: true, at: at}
+}
+
+// Exited returns who sent sig to pid, if known, and forgets the process.
+func (k *KillTracker) Exited(pid uint32, sig int32, at time.Time) (sentSignal, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s, ok := k.last[pid]
+	delete(k.last, pid)
+	if !ok || s.sig != sig || at.Sub(s.at) > killTrackerTTL {
+		return sentSignal{}, false
+	}
+	return s, true
+}
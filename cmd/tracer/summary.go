@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SyscallStat is one row of the --summary table: totals for one syscall of
+// one process.
+type SyscallStat struct {
+	PID    uint32
+	Comm   string
+	Nr     uint32
+	Name   string
+	Calls  uint64
+	Errors uint64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// Avg returns the mean latency of a call.
+func (s SyscallStat) Avg() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// collectSummary reads the in-kernel totals and names the processes.
+func collectSummary(loader *Loader, tree *ProcessTree, pid uint32) ([]SyscallStat, error) {
+	stats, err := loader.SyscallSummary(pid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		stats[i].Comm = tree.Comm(stats[i].PID)
+	}
+	return stats, nil
+}
+
+const summaryRule = "------ ----------- ----------- --------- --------- ----------- ----------------\n"
+
+// writeSummary prints the stats as strace -c does, one table per process,
+// busiest processes and syscalls first.
+func writeSummary(w io.Writer, stats []SyscallStat) {
+	byPID := make(map[uint32][]SyscallStat)
+	totals := make(map[uint32]time.Duration)
+	var pids []uint32
+	for _, s := range stats {
+		if _, ok := byPID[s.PID]; !ok {
+			pids = append(pids, s.PID)
+		}
+		byPID[s.PID] = append(byPID[s.PID], s)
+		totals[s.PID] += s.Total
+	}
+	sort.Slice(pids, func(i, j int) bool { return totals[pids[i]] > totals[pids[j]] })
+
+	for _, pid := range pids {
+		rows := byPID[pid]
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Total > rows[j].Total })
+		fmt.Fprintf(w, "\nPID %d (%s)\n", pid, rows[0].Comm)
+		fmt.Fprintf(w, "%6s %11s %11s %9s %9s %11s %s\n",
+			"% time", "seconds", "usecs/call", "calls", "errors", "max usecs", "syscall")
+		fmt.Fprint(w, summaryRule)
+		var calls, errors uint64
+		for _, s := range rows {
+			pct := 0.0
+			if totals[pid] > 0 {
+				pct = 100 * float64(s.Total) / float64(totals[pid])
+			}
+			fmt.Fprintf(w, "%6.2f %11.6f %11d %9d %9s %11d %s\n",
+				pct, s.Total.Seconds(), s.Avg().Microseconds(), s.Calls,
+				errorCount(s.Errors), s.Max.Microseconds(), s.Name)
+			calls += s.Calls
+			errors += s.Errors
+		}
+		fmt.Fprint(w, summaryRule)
+		fmt.Fprintf(w, "%6.2f %11.6f %11s %9d %9s %11s total\n",
+			100.0, totals[pid].Seconds(), "", calls, errorCount(errors), "")
+	}
+}
+
+// strace оставляет колонку ошибок пустой, если их не было
+func errorCount(n uint64) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", n)
+}
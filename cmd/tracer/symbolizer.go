@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// Кадров в стеке, MAX_STACK_DEPTH в tracer.h
+const maxStackDepth = 127
+
+// Сколько разобранных бинарей держим; при переполнении кэш сбрасывается
+const maxCachedBinaries = 256
+
+// То же для строк DWARF одного бинаря
+const maxCachedLines = 65536
+
+// StackFrame is one symbolized frame of a user or kernel stack.
+type StackFrame struct {
+	Address  uint64
+	Function string // "" если символ не найден
+	Offset   uint64 // от начала функции
+	Module   string // путь к бинарю, [vdso], "kernel" или имя модуля ядра
+	File     string // из DWARF, если есть
+	Line     int
+}
+
+func (f StackFrame) String() string {
+	s := fmt.Sprintf("0x%x", f.Address)
+	if f.Function != "" {
+		s = fmt.Sprintf("%s+0x%x", f.Function, f.Offset)
+	}
+	if f.File != "" {
+		s += fmt.Sprintf(" (%s:%d)", f.File, f.Line)
+	}
+	if f.Module != "" {
+		s += " [" + filepath.Base(f.Module) + "]"
+	}
+	return s
+}
+
+// formatStack joins frames innermost first, e.g. "read+0x10 < main+0x2c".
+func formatStack(frames []StackFrame) string {
+	parts := make([]string, len(frames))
+	for i, f := range frames {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, " < ")
+}
+
+type symbol struct {
+	addr   uint64
+	size   uint64 // 0 — неизвестен (kallsyms)
+	name   string
+	module string
+}
+
+// lookupSymbol finds the symbol covering addr in syms sorted by address.
+func lookupSymbol(syms []symbol, addr uint64) (symbol, bool) {
+	i := sort.Search(len(syms), func(i int) bool { return syms[i].addr > addr }) - 1
+	if i < 0 {
+		return symbol{}, false
+	}
+	s := syms[i]
+	if s.size != 0 && addr >= s.addr+s.size {
+		return symbol{}, false
+	}
+	return s, true
+}
+
+// elfBinary is what the symbolizer keeps of one executable or library.
+type elfBinary struct {
+	syms  []symbol
+	loads []elf.ProgHeader // PT_LOAD, для перевода смещения в файле в адрес
+	dwarf *dwarf.Data      // nil без отладочной информации
+	lines map[uint64]sourceLine
+}
+
+// Результат line(), и пустой тоже
+type sourceLine struct {
+	file string
+	line int
+}
+
+func loadELF(path string) (*elfBinary, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b := &elfBinary{}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			b.loads = append(b.loads, p.ProgHeader)
+		}
+	}
+	// symtab бывает вырезан strip-ом, dynsym остаётся у разделяемых библиотек
+	static, _ := f.Symbols()
+	dynamic, _ := f.DynamicSymbols()
+	seen := make(map[uint64]bool)
+	for _, s := range append(static, dynamic...) {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Value == 0 || seen[s.Value] {
+			continue
+		}
+		seen[s.Value] = true
+		b.syms = append(b.syms, symbol{addr: s.Value, size: s.Size, name: s.Name})
+	}
+	sort.Slice(b.syms, func(i, j int) bool { return b.syms[i].addr < b.syms[j].addr })
+	if f.Section(".debug_info") != nil {
+		b.dwarf, _ = f.DWARF()
+	}
+	return b, nil
+}
+
+// vaddr converts an offset in the file to the address the ELF was linked at.
+func (b *elfBinary) vaddr(off uint64) (uint64, bool) {
+	for _, p := range b.loads {
+		if off >= p.Off && off < p.Off+p.Filesz {
+			return off - p.Off + p.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// line returns the source position of pc from DWARF, if there is any.
+func (b *elfBinary) line(pc uint64) (string, int) {
+	if b.dwarf == nil {
+		return "", 0
+	}
+	cu, err := b.dwarf.Reader().SeekPC(pc)
+	if err != nil || cu == nil {
+		return "", 0
+	}
+	lr, err := b.dwarf.LineReader(cu)
+	if err != nil || lr == nil {
+		return "", 0
+	}
+	var e dwarf.LineEntry
+	if err := lr.SeekPC(pc, &e); err != nil || e.File == nil {
+		return "", 0
+	}
+	return e.File.Name, e.Line
+}
+
+// cachedLine is line with a cache per pc: SeekPC walks the line table of the
+// whole unit, and the same frames repeat in every stack. The cache is guarded
+// by Symbolizer.mu.
+func (b *elfBinary) cachedLine(pc uint64) (string, int) {
+	if b.dwarf == nil {
+		return "", 0
+	}
+	if l, ok := b.lines[pc]; ok {
+		return l.file, l.line
+	}
+	if b.lines == nil || len(b.lines) >= maxCachedLines {
+		b.lines = make(map[uint64]sourceLine)
+	}
+	file, line := b.line(pc)
+	b.lines[pc] = sourceLine{file, line}
+	return file, line
+}
+
+// Исполняемое отображение из /proc/<pid>/maps; path пуст у анонимной памяти (JIT)
+type procMapping struct {
+	start, end, offset uint64
+	path               string
+	key                string // dev:inode — один и тот же файл в разных mount namespace-ах
+}
+
+func findMapping(maps []procMapping, addr uint64) (procMapping, bool) {
+	for _, m := range maps {
+		if addr >= m.start && addr < m.end {
+			return m, true
+		}
+	}
+	return procMapping{}, false
+}
+
+func readProcMaps(pid uint32) ([]procMapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []procMapping
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// 7f2c4a000000-7f2c4a1c2000 r-xp 00026000 fd:01 1835 /usr/lib/x86_64-linux-gnu/libc.so.6
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 || !strings.Contains(fields[1], "x") {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			continue
+		}
+		m := procMapping{key: fields[3] + ":" + fields[4]}
+		if len(fields) > 5 {
+			m.path = strings.Join(fields[5:], " ")
+		}
+		m.start, _ = strconv.ParseUint(start, 16, 64)
+		m.end, _ = strconv.ParseUint(end, 16, 64)
+		m.offset, _ = strconv.ParseUint(fields[2], 16, 64)
+		out = append(out, m)
+	}
+	return out, sc.Err()
+}
+
+// Symbolizer reads captured stacks from stack_traces and names their frames:
+// kernel addresses from /proc/kallsyms, user addresses through
+// /proc/<pid>/maps and the ELF symbol tables (DWARF lines where present).
+type Symbolizer struct {
+	stacks *ebpf.Map
+	lost   atomic.Uint64 // стеки, не попавшие в stack_traces (-EEXIST)
+
+	mu       sync.Mutex
+	kernel   []symbol
+	kernelOK bool
+	binaries map[string]*elfBinary // по dev:inode; nil — бинарь не открылся
+	procs    map[uint32][]procMapping
+}
+
+func NewSymbolizer(stacks *ebpf.Map) *Symbolizer {
+	return &Symbolizer{
+		stacks:   stacks,
+		binaries: make(map[string]*elfBinary),
+		procs:    make(map[uint32][]procMapping),
+	}
+}
+
+// Forget drops the cached address space of pid (on exec and exit).
+func (s *Symbolizer) Forget(pid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.procs, pid)
+}
+
+// Lost returns how many stacks did not fit into stack_traces: the bucket of
+// their hash held another stack.
+func (s *Symbolizer) Lost() uint64 {
+	return s.lost.Load()
+}
+
+// addresses reads stack id from the map; the stack ends at the first zero.
+func (s *Symbolizer) addresses(id int32) []uint64 {
+	var ips [maxStackDepth]uint64
+	if id == -int32(unix.EEXIST) {
+		s.lost.Add(1)
+		return nil
+	}
+	if id < 0 || s.stacks.Lookup(uint32(id), &ips) != nil {
+		return nil
+	}
+	n := 0
+	for n < len(ips) && ips[n] != 0 {
+		n++
+	}
+	return ips[:n]
+}
+
+// Kernel symbolizes a kernel stack.
+func (s *Symbolizer) Kernel(id int32) []StackFrame {
+	ips := s.addresses(id)
+	if len(ips) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.kernelOK {
+		s.kernel = readKallsyms()
+		s.kernelOK = true
+	}
+	frames := make([]StackFrame, len(ips))
+	for i, ip := range ips {
+		frames[i] = StackFrame{Address: ip, Module: "kernel"}
+		if sym, ok := lookupSymbol(s.kernel, ip); ok {
+			frames[i].Function, frames[i].Offset = sym.name, ip-sym.addr
+			if sym.module != "" {
+				frames[i].Module = sym.module
+			}
+		}
+	}
+	return frames
+}
+
+// User symbolizes a user stack of pid.
+func (s *Symbolizer) User(pid uint32, id int32) []StackFrame {
+	ips := s.addresses(id)
+	if len(ips) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := make([]StackFrame, len(ips))
+	for i, ip := range ips {
+		frames[i] = StackFrame{Address: ip}
+		// Кроме вершины, в стеке адреса возврата: ищем по инструкции вызова
+		pc := ip
+		if i > 0 {
+			pc--
+		}
+		m, ok := s.mapping(pid, pc)
+		if !ok {
+			continue
+		}
+		frames[i].Module = m.path
+		if !strings.HasPrefix(m.path, "/") {
+			continue
+		}
+		b := s.binary(pid, m)
+		if b == nil {
+			continue
+		}
+		addr, ok := b.vaddr(pc - m.start + m.offset)
+		if !ok {
+			continue
+		}
+		if sym, ok := lookupSymbol(b.syms, addr); ok {
+			frames[i].Function, frames[i].Offset = sym.name, addr-sym.addr
+			if i > 0 {
+				frames[i].Offset++
+			}
+		}
+		frames[i].File, frames[i].Line = b.cachedLine(addr)
+	}
+	return frames
+}
+
+// mapping finds the mapping of addr, re-reading maps once in case a library
+// was loaded after they were cached. Must be called with s.mu held.
+func (s *Symbolizer) mapping(pid uint32, addr uint64) (procMapping, bool) {
+	if maps, ok := s.procs[pid]; ok {
+		if m, ok := findMapping(maps, addr); ok {
+			return m, true
+		}
+	}
+	maps, err := readProcMaps(pid)
+	if err != nil {
+		return procMapping{}, false
+	}
+	s.procs[pid] = maps
+	return findMapping(maps, addr)
+}
+
+// binary returns the parsed ELF behind m, opened through the process' root
+// so binaries in containers are found. Must be called with s.mu held.
+func (s *Symbolizer) binary(pid uint32, m procMapping) *elfBinary {
+	if b, ok := s.binaries[m.key]; ok {
+		return b
+	}
+	if len(s.binaries) >= maxCachedBinaries {
+		s.binaries = make(map[string]*elfBinary)
+	}
+	b, err := loadELF(fmt.Sprintf("/proc/%d/root%s", pid, m.path))
+	if err != nil {
+		b, _ = loadELF(m.path)
+	}
+	s.binaries[m.key] = b
+	return b
+}
+
+// readKallsyms loads the kernel's function symbols; without root the
+// addresses are zeroed and nothing resolves.
+func readKallsyms() []symbol {
+	f, err := os.Open("/proc/kallsyms")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var syms []symbol
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// ffffffffc0a01000 t nf_nat_ipv4_fn	[nf_nat]
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || (fields[1] != "t" && fields[1] != "T") {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil || addr == 0 {
+			continue
+		}
+		sym := symbol{addr: addr, name: fields[2]}
+		if len(fields) > 3 {
+			sym.module = strings.Trim(fields[3], "[]")
+		}
+		syms = append(syms, sym)
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].addr < syms[j].addr })
+	return syms
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	syscallNumbersOnce sync.Once
+	syscallNumbers     map[string]uint32
+)
+
+// syscallName returns the name of syscall nr on this architecture.
+func syscallName(nr uint32) string {
+	if name, ok := syscallNames[nr]; ok {
+		return name
+	}
+	return fmt.Sprintf("syscall_%d", nr)
+}
+
+// syscallNumber accepts a syscall name or number.
+func syscallNumber(s string) (uint32, bool) {
+	if nr, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(nr), true
+	}
+	syscallNumbersOnce.Do(func() {
+		syscallNumbers = make(map[string]uint32, len(syscallNames))
+		for nr, name := range syscallNames {
+			syscallNumbers[name] = nr
+		}
+	})
+	nr, ok := syscallNumbers[strings.ToLower(s)]
+	return nr, ok
+}
+
+// syscallResult renders a return value the way strace does: "-1 ENOENT" for
+// errors, hex for values that look like addresses.
+func syscallResult(ret int64) string {
+	if ret < 0 && ret >= -4095 {
+		errno := syscall.Errno(-ret)
+		if name := unix.ErrnoName(errno); name != "" {
+			return fmt.Sprintf("-1 %s (%s)", name, errno.Error())
+		}
+		return fmt.Sprintf("-1 errno %d", -ret)
+	}
+	if ret > 0xffff_ffff || ret < 0 {
+		return fmt.Sprintf("0x%x", uint64(ret))
+	}
+	return strconv.FormatInt(ret, 10)
+}
+
+// formatSyscall renders a SYSCALL event strace-style. The arity of a syscall
+// is not known here, so all six raw arguments are shown.
+func formatSyscall(info *SyscallInfo) string {
+	args := make([]string, len(info.Args))
+	for i, a := range info.Args {
+		args[i] = fmt.Sprintf("0x%x", a)
+	}
+	return fmt.Sprintf("%s(%s) = %s <%.6f>", info.Name, strings.Join(args, ", "),
+		syscallResult(info.Ret), info.Duration.Seconds())
+}
@@ -0,0 +1,6 @@
+//go:build !amd64 && !arm64
+
+package main
+
+// Таблицы имён есть только для x86_64 и arm64; на остальных — только номера
+var syscallNames = map[uint32]string{}
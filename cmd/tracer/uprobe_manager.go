@@ -14,27 +14,50 @@ import (
 type UprobeManager struct {
     links    map[string]link.Link      // Ключ: binary:function:pid
     prog     *ebpf.Program
+    retProg  *ebpf.Program             // uretprobe для --hist
     uconfMap *ebpf.Map                 // карта uprobe_configs
+    latency  bool                      // вешать uretprobe рядом с uprobe
+    names    map[uint64]string         // cookie привязки (или адрес функции) -> имя, для гистограмм
+    cookies  bool                      // программы узнают функцию по cookie, а не по адресу
+    nextID   uint64
 }
 
+// NewUprobeManager uses whichever uprobe programs NewLoader loaded; without
+// --uprobes there are none and AddUprobe fails.
 func NewUprobeManager(coll *ebpf.Collection) (*UprobeManager, error) {
-    prog := coll.Programs["handle_generic_uprobe"]
-    if prog == nil {
-        return nil, errors.New("eBPF program 'handle_generic_uprobe' not found")
+    m := &UprobeManager{
+        links:    make(map[string]link.Link),
+        uconfMap: coll.Maps["uprobe_configs"],
+        names:    make(map[uint64]string),
     }
-    uconf := coll.Maps["uprobe_configs"]
-    if uconf == nil {
-        return nil, errors.New("eBPF map 'uprobe_configs' not found")
+    m.prog, m.retProg = coll.Programs["handle_generic_uprobe"], coll.Programs["handle_generic_uretprobe"]
+    m.cookies = m.prog != nil
+    if !m.cookies {
+        m.prog, m.retProg = coll.Programs["handle_generic_uprobe_addr"], coll.Programs["handle_generic_uretprobe_addr"]
     }
-    return &UprobeManager{
-        links:    make(map[string]link.Link),
-        prog:     prog,
-        uconfMap: uconf,
-    }, nil
+    if m.prog != nil && (m.retProg == nil || m.uconfMap == nil) {
+        return nil, errors.New("eBPF uretprobe program or map 'uprobe_configs' not found")
+    }
+    return m, nil
+}
+
+// SetLatency makes uprobes added afterwards also attach a uretprobe, so that
+// --hist can measure how long each call takes.
+func (m *UprobeManager) SetLatency(enabled bool) {
+    m.latency = enabled
+}
+
+// FunctionName returns the name of the function attached with cookie id (on
+// kernels without cookies, at address id), or "".
+func (m *UprobeManager) FunctionName(id uint64) string {
+    return m.names[id]
 }
 
 // Добавляет uprobe на указанную функцию указанного бинаря, с фильтром PID (0 = для всех)
 func (m *UprobeManager) AddUprobe(pid int, binaryPath, functionName string) error {
+    if m.prog == nil {
+        return errors.New("uprobe programs are not loaded, start the tracer with --uprobes")
+    }
     // 1. Открываем ELF для поиска смещения (адреса) функции
     ex, err := elf.Open(binaryPath)
     if err != nil {
@@ -57,26 +80,51 @@ func (m *UprobeManager) AddUprobe(pid int, binaryPath, functionName string) erro
         return fmt.Errorf("function '%s' not found in %s", functionName, binaryPath)
     }
 
-    // 2. Подключаем eBPF-программу к этой функции через uprobe
+    // 2. Подключаем eBPF-программу к этой функции через uprobe. Программа
+    // узнаёт функцию по cookie, если ядро их умеет: IP в PIE и библиотеках
+    // зависит от адреса загрузки
     exe, err := link.OpenExecutable(binaryPath)
     if err != nil {
         return fmt.Errorf("open executable: %w", err)
     }
+    // Без cookie программа ищет функцию по pid и адресу входа, а гистограммы
+    // ключует адресом
+    key, id := uint64(pid)<<32|funcAddr, funcAddr
     opts := link.UprobeOptions{PID: pid}
+    if m.cookies {
+        m.nextID++
+        key, id = m.nextID, m.nextID
+        opts.Cookie = id
+    }
     uprobe, err := exe.Uprobe(functionName, m.prog, &opts)
     if err != nil {
         return fmt.Errorf("attach uprobe: %w", err)
     }
+    var uretprobe link.Link
+    if m.latency {
+        uretprobe, err = exe.Uretprobe(functionName, m.retProg, &opts)
+        if err != nil {
+            uprobe.Close()
+            return fmt.Errorf("attach uretprobe: %w", err)
+        }
+    }
 
-    // 3. Кладем ключ/значение в eBPF map для привязки адреса к имени
-    key := (uint64(pid) << 32) | funcAddr
+    // 3. Кладем ключ/значение в eBPF map для привязки cookie (или pid и адреса) к имени
     value := make([]byte, 64)
     copy(value, []byte(functionName))
     if err := m.uconfMap.Put(unsafe.Pointer(&key), unsafe.Pointer(&value[0])); err != nil {
+        uprobe.Close()
+        if uretprobe != nil {
+            uretprobe.Close()
+        }
         return fmt.Errorf("update uprobe_configs map: %w", err)
     }
 
     m.links[fmt.Sprintf("%s:%s:%d", binaryPath, functionName, pid)] = uprobe
+    if uretprobe != nil {
+        m.links[fmt.Sprintf("%s:%s:%d:ret", binaryPath, functionName, pid)] = uretprobe
+    }
+    m.names[id] = functionName
     log.Printf("UPROBE attached: %s:%s (pid=%d, addr=0x%x)", binaryPath, functionName, pid, funcAddr)
     return nil
 }
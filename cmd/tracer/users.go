@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const passwdPath = "/etc/passwd"
+
+// Неизвестный UID не должен приводить к stat на каждое событие
+const passwdRecheckInterval = 2 * time.Second
+
+// UserResolver maps UIDs to user names from /etc/passwd, re-reading the file
+// when it changes.
+type UserResolver struct {
+	mu        sync.Mutex
+	names     map[uint32]string
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func NewUserResolver() *UserResolver {
+	r := &UserResolver{names: make(map[uint32]string)}
+	r.reload()
+	return r
+}
+
+// Name returns the user name for uid, or the numeric uid if it is unknown.
+func (r *UserResolver) Name(uid uint32) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name, ok := r.names[uid]
+	if !ok && r.reload() {
+		name, ok = r.names[uid]
+	}
+	if !ok {
+		return strconv.FormatUint(uint64(uid), 10)
+	}
+	return name
+}
+
+// reload re-reads /etc/passwd if its mtime changed; reports whether it did.
+func (r *UserResolver) reload() bool {
+	if time.Since(r.lastCheck) < passwdRecheckInterval {
+		return false
+	}
+	r.lastCheck = time.Now()
+	st, err := os.Stat(passwdPath)
+	if err != nil || st.ModTime().Equal(r.modTime) {
+		return false
+	}
+	entries, err := parsePasswd(passwdPath)
+	if err != nil {
+		return false
+	}
+	// Несколько имён на один UID (root и toor): для вывода берём первое
+	names := make(map[uint32]string)
+	for _, e := range entries {
+		if _, dup := names[e.uid]; !dup {
+			names[e.uid] = e.name
+		}
+	}
+	r.names = names
+	r.modTime = st.ModTime()
+	return true
+}
+
+type passwdEntry struct {
+	name string
+	uid  uint32
+}
+
+// parsePasswd returns the passwd entries in file order.
+func parsePasswd(path string) ([]passwdEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []passwdEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(sc.Text(), ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uint32(uid)})
+	}
+	return entries, sc.Err()
+}
+
+// LookupUID accepts a numeric uid or a user name from /etc/passwd.
+func LookupUID(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if uid, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+	entries, err := parsePasswd(passwdPath)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if e.name == s {
+			return e.uid, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown user %q", s)
+}
@@ -33,7 +33,29 @@ EVENT_TYPES = [
     "CLONE",
     "EXIT",
     "TCP_CONN",
-    "UPROBE"
+    "UPROBE",
+    "CLOSE",
+    "DATA",
+    "UNLINK",
+    "RENAME",
+    "CHMOD",
+    "CHOWN",
+    "MKDIR",
+    "RMDIR",
+    "TRUNCATE",
+    "UDP",
+    "DNS",
+    "SIGNAL",
+    "CRED",
+    "CAPABLE",
+    "MODULE",
+    "BPF",
+    "MMAP_EXEC",
+    "NAMESPACE",
+    "MOUNT",
+    "OOM",
+    "SYSCALL",
+    "BLOCK_IO"
 ]
 
 def clean_str(s, max_len=200):